@@ -0,0 +1,370 @@
+package web
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxMemory 解析 multipart 表单的时候，最多在内存里面缓存多少数据
+// 超过的部分会被写到临时文件里面，和 net/http 保持一致
+const defaultMaxMemory = 32 << 20
+
+// bindSources 按照优先级排列的数据来源，也就是字段上的标签
+// 一个字段可以同时声明多个来源，先找到的优先
+var bindSources = []string{"path", "query", "header", "cookie", "form"}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// BindFieldError 代表某个字段绑定失败
+type BindFieldError struct {
+	// Field 字段名，嵌套的结构体用 . 连接，比如说 Address.City
+	Field string
+	// Source 数据来源，比如说 query, header。请求体解析失败的时候是 body
+	Source string
+	// Key 数据来源里面的名字，比如说 query:"page" 里面的 page
+	Key string
+	// Value 原始的输入
+	Value string
+	Err   error
+}
+
+func (e *BindFieldError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("web: 解析 %s 失败: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("web: 字段 %s 绑定失败, %s:%q 的值 %q 不合法: %v",
+		e.Field, e.Source, e.Key, e.Value, e.Err)
+}
+
+func (e *BindFieldError) Unwrap() error {
+	return e.Err
+}
+
+// BindErrors 聚合了所有字段的错误
+// 这样用户一次就能知道所有输入不对的字段，而不是改一个报一个
+type BindErrors []*BindFieldError
+
+func (e BindErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Bind 根据字段上的标签，从请求的各个部分把数据绑定到 val 上，val 必须是结构体指针。
+// 支持的标签有：
+//
+//	path:"id"          路径参数
+//	query:"page"       查询参数
+//	header:"X-Tenant"  请求头
+//	cookie:"sid"       cookie
+//	form:"name"        表单，包括 multipart 表单
+//	layout:"2006-01-02" time.Time 的格式，默认是 RFC3339
+//
+// 如果有请求体，那么会先按照 Content-Type 选择 Codec 解析请求体，比如说 JSON 就是按照 json 标签，
+// 然后再用其它部分覆盖。表单则是通过 form 标签绑定的。
+// 切片可以接收重复的 key，指针代表可选的值，只有输入里面有才会被设置。
+// 此外也支持 time.Time, time.Duration 和实现了 encoding.TextUnmarshaler 的类型。
+// 所有字段的错误会被聚合成一个 BindErrors 返回
+func (c *Context) Bind(val any) error {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("web: 只能绑定到结构体指针")
+	}
+	if c.needBindBody(rv.Elem().Type()) {
+		if err := c.BindBody(val); err != nil {
+			if errors.Is(err, ErrUnsupportedMediaType) {
+				return err
+			}
+			return BindErrors{{Source: "body", Err: err}}
+		}
+	}
+	var errs BindErrors
+	bindStruct(rv.Elem(), "", c.bindValues, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// needBindBody 请求体是否需要交给 Codec 来解析
+// 表单是通过 form 标签来绑定的，所以这里不需要处理。
+// 有对应 Codec 的请求体总是会解析，其余的只有结构体里面有需要从请求体获取数据的字段才解析，
+// 这个时候会返回 ErrUnsupportedMediaType。
+// 不然只声明了 query 之类标签的结构体，会因为客户端随便带了一个请求体而绑定失败
+func (c *Context) needBindBody(typ reflect.Type) bool {
+	if c.Req.Body == nil || c.Req.Body == http.NoBody {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(c.Req.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+		return false
+	}
+	if _, ok := c.codec(mediaType); ok && mediaType != "" {
+		return true
+	}
+	return hasBodyFields(typ, make(map[reflect.Type]bool, 4))
+}
+
+// hasBodyFields 有没有没声明数据来源的字段，这些字段只能从请求体里面获取数据
+// 没有标签的结构体是递归进去绑定的，所以要看里面的字段
+func hasBodyFields(typ reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[typ] {
+		return false
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+	for i := 0; i < typ.NumField(); i++ {
+		fd := typ.Field(i)
+		if !fd.IsExported() || hasBindTag(fd) {
+			continue
+		}
+		ft := fd.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct || isScalarStruct(ft) {
+			return true
+		}
+		if hasBodyFields(ft, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBindTag(fd reflect.StructField) bool {
+	for _, source := range bindSources {
+		if _, ok := fd.Tag.Lookup(source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesGetter 从 source 里面找到 key 对应的所有值
+type valuesGetter func(source string, key string) ([]string, error)
+
+func bindStruct(v reflect.Value, prefix string, getter valuesGetter, errs *BindErrors) {
+	b := &structBinder{getter: getter, errs: errs, visiting: make(map[reflect.Type]bool, 4)}
+	b.bind(v, prefix)
+}
+
+type structBinder struct {
+	getter valuesGetter
+	errs   *BindErrors
+	// 正在绑定的结构体类型，用来发现 type Node struct{ Next *Node } 这种自引用
+	visiting map[reflect.Type]bool
+}
+
+// bind 返回值代表有没有字段收到了输入，绑定失败的也算
+func (b *structBinder) bind(v reflect.Value, prefix string) bool {
+	typ := v.Type()
+	// 自引用的类型每一层的标签都是一样的，递归下去也拿不到新的数据，只会栈溢出
+	if b.visiting[typ] {
+		return false
+	}
+	b.visiting[typ] = true
+	defer delete(b.visiting, typ)
+
+	bound := false
+	for i := 0; i < typ.NumField(); i++ {
+		fd := typ.Field(i)
+		if !fd.IsExported() {
+			continue
+		}
+		fieldName := prefix + fd.Name
+		fv := v.Field(i)
+		if tagged, ok := bindField(fv, fd, fieldName, b.getter, b.errs); tagged {
+			bound = bound || ok
+			continue
+		}
+		// 没有标签的结构体，包括组合进来的结构体，递归下去
+		ft := fd.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct || isScalarStruct(ft) {
+			continue
+		}
+		nestedPrefix := fieldName + "."
+		if fd.Anonymous {
+			nestedPrefix = prefix
+		}
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				// 只有真的收到了输入才分配，不然可选的结构体也会变成非 nil
+				nv := reflect.New(ft)
+				if b.bind(nv.Elem(), nestedPrefix) {
+					fv.Set(nv)
+					bound = true
+				}
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if b.bind(fv, nestedPrefix) {
+			bound = true
+		}
+	}
+	return bound
+}
+
+// bindField 返回值 tagged 代表这个字段有没有声明数据来源，bound 代表有没有收到输入
+func bindField(fv reflect.Value, fd reflect.StructField, fieldName string, getter valuesGetter, errs *BindErrors) (tagged bool, bound bool) {
+	for _, source := range bindSources {
+		key, ok := fd.Tag.Lookup(source)
+		if !ok || key == "-" {
+			continue
+		}
+		tagged = true
+		vals, err := getter(source, key)
+		if err != nil {
+			*errs = append(*errs, &BindFieldError{Field: fieldName, Source: source, Key: key, Err: err})
+			return true, true
+		}
+		if len(vals) == 0 {
+			continue
+		}
+		if err = setValue(fv, vals, fd.Tag.Get("layout")); err != nil {
+			*errs = append(*errs, &BindFieldError{
+				Field:  fieldName,
+				Source: source,
+				Key:    key,
+				Value:  strings.Join(vals, ","),
+				Err:    err,
+			})
+		}
+		return true, true
+	}
+	return tagged, false
+}
+
+// bindValues 从 source 里面找到 key 对应的所有值
+func (c *Context) bindValues(source string, key string) ([]string, error) {
+	switch source {
+	case "path":
+		val, ok := c.PathParams[key]
+		if !ok {
+			return nil, nil
+		}
+		return []string{val}, nil
+	case "query":
+		if c.queryValues == nil {
+			c.queryValues = c.Req.URL.Query()
+		}
+		return c.queryValues[key], nil
+	case "header":
+		return c.Req.Header.Values(key), nil
+	case "cookie":
+		var vals []string
+		for _, ck := range c.Req.Cookies() {
+			if ck.Name == key {
+				vals = append(vals, ck.Value)
+			}
+		}
+		return vals, nil
+	case "form":
+		// 重复调用是没有开销的，net/http 会判断已经解析过了
+		err := c.Req.ParseMultipartForm(defaultMaxMemory)
+		if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, err
+		}
+		return c.Req.Form[key], nil
+	}
+	return nil, fmt.Errorf("web: 不支持的数据来源 %s", source)
+}
+
+// isScalarStruct 这些结构体是被当做一个值来处理的，而不是递归进去
+func isScalarStruct(typ reflect.Type) bool {
+	return typ == timeType || reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+// setValue 把字符串形式的输入转换之后设置到 v 上
+// 切片会用上所有的输入，其它类型只用第一个
+// layout 是 time.Time 的格式，为空的话就是 RFC3339
+func setValue(v reflect.Value, vals []string, layout string) error {
+	if v.Kind() == reflect.Pointer {
+		elem := reflect.New(v.Type().Elem())
+		if err := setValue(elem.Elem(), vals, layout); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+	typ := v.Type()
+	switch {
+	case typ == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, vals[0])
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case typ == durationType:
+		d, err := time.ParseDuration(vals[0])
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case reflect.PointerTo(typ).Implements(textUnmarshalerType):
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(vals[0]))
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(typ, len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(slice.Index(i), []string{val}, layout); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.String:
+		v.SetString(vals[0])
+	case reflect.Bool:
+		b, err := strconv.ParseBool(vals[0])
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(vals[0], 10, typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(vals[0], 10, typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(vals[0], typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("web: 不支持的类型 %s", typ)
+	}
+	return nil
+}
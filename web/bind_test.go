@@ -0,0 +1,260 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bindLevel int
+
+func (l *bindLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return errors.New("未知的级别")
+	}
+	return nil
+}
+
+type bindAddress struct {
+	City string `query:"city"`
+}
+
+type BindPage struct {
+	Page int `query:"page"`
+}
+
+type bindReq struct {
+	BindPage
+	ID       int64         `path:"id"`
+	Tags     []string      `query:"tag"`
+	Size     *int          `query:"size"`
+	Tenant   string        `header:"X-Tenant"`
+	Session  string        `cookie:"sid"`
+	Name     string        `json:"name" form:"name"`
+	Age      uint8         `json:"age"`
+	Birthday time.Time     `query:"birthday" layout:"2006-01-02"`
+	Timeout  time.Duration `query:"timeout"`
+	Level    bindLevel     `query:"level"`
+	Address  bindAddress
+}
+
+func TestContext_Bind(t *testing.T) {
+	size := 10
+	testCases := []struct {
+		name    string
+		req     func() *http.Request
+		params  map[string]string
+		wantVal bindReq
+		wantErr error
+	}{
+		{
+			name: "all sources",
+			req: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost,
+					"/user/123?tag=a&tag=b&size=10&page=2&birthday=2022-10-01&timeout=3s&level=high&city=shenzhen",
+					bytes.NewBufferString(`{"name":"Tom","age":18}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/json; charset=utf-8")
+				req.Header.Set("X-Tenant", "acme")
+				req.AddCookie(&http.Cookie{Name: "sid", Value: "session"})
+				return req
+			},
+			params: map[string]string{"id": "123"},
+			wantVal: bindReq{
+				BindPage: BindPage{Page: 2},
+				ID:       123,
+				Tags:     []string{"a", "b"},
+				Size:     &size,
+				Tenant:   "acme",
+				Session:  "session",
+				Name:     "Tom",
+				Age:      18,
+				Birthday: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+				Timeout:  3 * time.Second,
+				Level:    2,
+				Address:  bindAddress{City: "shenzhen"},
+			},
+		},
+		{
+			name: "form",
+			req: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, "/user/123", strings.NewReader("name=Jerry"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantVal: bindReq{
+				Name: "Jerry",
+			},
+		},
+		{
+			name: "invalid json",
+			req: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, "/user/123", bytes.NewBufferString(`{`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			wantErr: BindErrors{{Source: "body", Err: errors.New("unexpected EOF")}},
+		},
+		{
+			// 所有字段的错误都会被返回
+			name: "invalid fields",
+			req: func() *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/user/abc?size=xx&level=middle&city=beijing", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return req
+			},
+			params: map[string]string{"id": "abc"},
+			wantVal: bindReq{
+				Address: bindAddress{City: "beijing"},
+			},
+			wantErr: BindErrors{
+				{Field: "ID", Source: "path", Key: "id", Value: "abc"},
+				{Field: "Size", Source: "query", Key: "size", Value: "xx"},
+				{Field: "Level", Source: "query", Key: "level", Value: "middle"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{Req: tc.req(), PathParams: tc.params}
+			var val bindReq
+			err := ctx.Bind(&val)
+			if tc.wantErr != nil {
+				var errs BindErrors
+				assert.True(t, errors.As(err, &errs))
+				wantErrs := tc.wantErr.(BindErrors)
+				assert.Equal(t, len(wantErrs), len(errs))
+				for i, fe := range errs {
+					assert.Equal(t, wantErrs[i].Field, fe.Field)
+					assert.Equal(t, wantErrs[i].Source, fe.Source)
+					assert.Equal(t, wantErrs[i].Key, fe.Key)
+					assert.Equal(t, wantErrs[i].Value, fe.Value)
+					assert.NotNil(t, fe.Err)
+				}
+				if tc.wantErr.(BindErrors)[0].Source == "body" {
+					return
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestContext_BindInvalidVal(t *testing.T) {
+	ctx := &Context{Req: &http.Request{}}
+	var val bindReq
+	assert.Error(t, ctx.Bind(val))
+	assert.Error(t, ctx.Bind((*bindReq)(nil)))
+}
+
+type bindNode struct {
+	Name string `query:"name"`
+	Next *bindNode
+}
+
+type bindOptional struct {
+	Page    int `query:"page"`
+	Address *bindAddress
+	Node    *bindNode
+}
+
+func TestContext_BindNestedPointer(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		wantVal bindOptional
+	}{
+		{
+			// 自引用的类型不能栈溢出，没有输入的指针保持 nil
+			name:    "no nested input",
+			query:   "page=1",
+			wantVal: bindOptional{Page: 1},
+		},
+		{
+			name:    "nested input",
+			query:   "page=1&city=shenzhen&name=a",
+			wantVal: bindOptional{Page: 1, Address: &bindAddress{City: "shenzhen"}, Node: &bindNode{Name: "a"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/?"+tc.query, nil)
+			assert.NoError(t, err)
+			var val bindOptional
+			assert.NoError(t, (&Context{Req: req}).Bind(&val))
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestContext_BindWithoutBodyFields(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		val         any
+		wantErr     error
+	}{
+		{
+			// 只用了 query 的结构体，请求体是什么都不影响
+			name: "no content type",
+			val:  &BindPage{},
+		},
+		{
+			name:        "unsupported",
+			contentType: "application/octet-stream",
+			val:         &BindPage{},
+		},
+		{
+			// text/plain 只用来渲染响应，不会用来解析请求体
+			name:        "text",
+			contentType: "text/plain",
+			val:         &BindPage{},
+		},
+		{
+			// 需要请求体的结构体依旧会报错
+			name:        "body fields",
+			contentType: "application/octet-stream",
+			val:         &bindReq{},
+			wantErr:     ErrUnsupportedMediaType,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/?page=2", strings.NewReader("abc"))
+			assert.NoError(t, err)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			err = (&Context{Req: req}).Bind(tc.val)
+			if tc.wantErr != nil {
+				assert.True(t, errors.Is(err, tc.wantErr))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &BindPage{Page: 2}, tc.val)
+		})
+	}
+}
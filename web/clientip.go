@@ -0,0 +1,212 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ServerWithTrustedProxies 设置可信的代理，比如说负载均衡的网段
+// 只有直接连上来的对端在这些网段里面，才会相信 ServerWithForwardedHeader 指定的头部，
+// 不然这些头部可以被客户端随便伪造。cidrs 也可以是单个 IP
+func ServerWithTrustedProxies(cidrs ...string) HTTPServerOption {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("web: 非法的可信代理 %s: %v", cidr, err))
+		}
+		nets = append(nets, ipNet)
+	}
+	return func(server *HTTPServer) {
+		server.trustedProxies = nets
+	}
+}
+
+// ForwardedHeader 可信代理用哪个头部来传递客户端的信息
+// 必须和代理的配置保持一致：大多数代理只会追加自己使用的那个头部，
+// 其它的头部会被原样转发，里面的内容完全是客户端控制的
+type ForwardedHeader uint8
+
+const (
+	// ForwardedHeaderXForwarded X-Forwarded-For, X-Forwarded-Proto 和 X-Forwarded-Host，这是默认值
+	ForwardedHeaderXForwarded ForwardedHeader = iota
+	// ForwardedHeaderForwarded RFC 7239 的 Forwarded
+	ForwardedHeaderForwarded
+	// ForwardedHeaderXRealIP 只有 X-Real-IP，比如说 nginx 的 proxy_set_header X-Real-IP $remote_addr
+	ForwardedHeaderXRealIP
+)
+
+// ServerWithForwardedHeader 设置可信代理使用的头部，要和 ServerWithTrustedProxies 一起使用
+func ServerWithForwardedHeader(header ForwardedHeader) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.forwardedHeader = header
+	}
+}
+
+// forwardedHop 代理记录下来的一跳，也就是它看到的对端，以及对端请求使用的协议和 Host
+type forwardedHop struct {
+	ip    string
+	proto string
+	host  string
+}
+
+// ClientIP 客户端的真实 IP
+// 直接连上来的是可信代理的时候，从右往左遍历 ServerWithForwardedHeader 指定的头部，
+// 跳过可信的代理，第一个不可信的就是客户端
+func (c *Context) ClientIP() string {
+	if hop, ok := c.clientHop(); ok {
+		return hop.ip
+	}
+	return remoteIP(c.Req.RemoteAddr)
+}
+
+// Scheme 客户端请求使用的协议，http 或者 https
+// 和 ClientIP 一样，用的是记录了客户端的那一跳，不然最左边的值是客户端可以随便伪造的
+func (c *Context) Scheme() string {
+	if hop, ok := c.clientHop(); ok && hop.proto != "" {
+		return strings.ToLower(hop.proto)
+	}
+	if c.Req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host 客户端请求的 Host，取值的规则和 Scheme 一样
+func (c *Context) Host() string {
+	if hop, ok := c.clientHop(); ok && hop.host != "" {
+		return hop.host
+	}
+	return c.Req.Host
+}
+
+// clientHop 从右往左遍历代理记录下来的每一跳，跳过可信的代理，第一个不可信的就是客户端
+// 所有的都是可信代理的话，就是最左边的那一跳
+func (c *Context) clientHop() (forwardedHop, bool) {
+	if !c.trusted(remoteIP(c.Req.RemoteAddr)) {
+		return forwardedHop{}, false
+	}
+	hops := c.forwardedHops()
+	var res forwardedHop
+	found := false
+	for i := len(hops) - 1; i >= 0; i-- {
+		// 比如说 for=unknown
+		if hops[i].ip == "" {
+			continue
+		}
+		res, found = hops[i], true
+		if !c.trusted(res.ip) {
+			break
+		}
+	}
+	return res, found
+}
+
+// forwardedHops 按照出现的顺序，解析出每一跳
+func (c *Context) forwardedHops() []forwardedHop {
+	header := ForwardedHeaderXForwarded
+	if c.server != nil {
+		header = c.server.forwardedHeader
+	}
+	switch header {
+	case ForwardedHeaderForwarded:
+		// Forwarded: for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"
+		elems := headerTokens(c.Req.Header, "Forwarded")
+		hops := make([]forwardedHop, 0, len(elems))
+		for _, elem := range elems {
+			var hop forwardedHop
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(strings.TrimSpace(v), `"`)
+				switch strings.ToLower(strings.TrimSpace(k)) {
+				case "for":
+					hop.ip = forwardedIP(v)
+				case "proto":
+					hop.proto = v
+				case "host":
+					hop.host = v
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	case ForwardedHeaderXRealIP:
+		if ip := forwardedIP(c.Req.Header.Get("X-Real-IP")); ip != "" {
+			return []forwardedHop{{ip: ip}}
+		}
+		return nil
+	}
+	fors := headerTokens(c.Req.Header, "X-Forwarded-For")
+	protos := headerTokens(c.Req.Header, "X-Forwarded-Proto")
+	hosts := headerTokens(c.Req.Header, "X-Forwarded-Host")
+	hops := make([]forwardedHop, len(fors))
+	for i, val := range fors {
+		hops[i] = forwardedHop{
+			ip:    forwardedIP(val),
+			proto: alignedValue(protos, len(fors), i),
+			host:  alignedValue(hosts, len(fors), i),
+		}
+	}
+	return hops
+}
+
+// alignedValue X-Forwarded-Proto 和 X-Forwarded-Host 对应到 X-Forwarded-For 的第 i 跳
+// 每一跳的代理都追加了的话，数量是一样的，按照位置对应。
+// 不然一般是最外层的代理设置的，或者客户端自己伪造了一部分，这时候只有最右边的那个是代理设置的
+func alignedValue(vals []string, hops int, i int) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	if len(vals) == hops {
+		return vals[i]
+	}
+	return vals[len(vals)-1]
+}
+
+func (c *Context) trusted(ip string) bool {
+	if c.server == nil || len(c.server.trustedProxies) == 0 {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range c.server.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP 去掉端口和 IPv6 的方括号，不是 IP 的话，比如说 unknown，返回空字符串
+func forwardedIP(val string) string {
+	val = strings.TrimSpace(val)
+	if host, _, err := net.SplitHostPort(val); err == nil {
+		val = host
+	}
+	val = strings.TrimSuffix(strings.TrimPrefix(val, "["), "]")
+	if ip := net.ParseIP(val); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext_ClientIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		forwarded  ForwardedHeader
+		remoteAddr string
+		header     map[string]string
+		tls        bool
+
+		wantIP     string
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			// 不可信的对端，头部都是可以伪造的
+			name:       "untrusted",
+			remoteAddr: "1.2.3.4:1234",
+			header: map[string]string{
+				"X-Forwarded-For":   "5.6.7.8",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "evil.com",
+			},
+			wantIP:     "1.2.3.4",
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				// 最左边的是客户端自己伪造的
+				"X-Forwarded-For":   "6.6.6.6, 5.6.7.8, 10.0.0.2",
+				"X-Forwarded-Proto": "HTTPS",
+				"X-Forwarded-Host":  "api.example.com",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "api.example.com",
+		},
+		{
+			// 所有的都是可信代理，那么最左边的就是客户端
+			name:       "all trusted",
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "192.168.1.1, 10.0.0.2"},
+			wantIP:     "192.168.1.1",
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			// 代理只追加 X-Forwarded-For，客户端伪造的 Forwarded 会被原样转发
+			name:       "injected forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				"Forwarded":       `for=10.0.0.3;proto=https;host=evil.com`,
+				"X-Forwarded-For": "5.6.7.8",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			// 客户端伪造的在左边，代理追加的在右边
+			name:       "injected x-forwarded-host",
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				"X-Forwarded-For":   "5.6.7.8",
+				"X-Forwarded-Proto": "http, https",
+				"X-Forwarded-Host":  "evil.com, api.example.com",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "api.example.com",
+		},
+		{
+			// 每一跳都追加了，用的是记录了客户端的那一跳
+			name:       "x-forwarded per hop",
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				"X-Forwarded-For":   "6.6.6.6, 5.6.7.8, 10.0.0.2",
+				"X-Forwarded-Proto": "http, https, http",
+				"X-Forwarded-Host":  "evil.com, api.example.com, internal",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "api.example.com",
+		},
+		{
+			name:       "forwarded",
+			forwarded:  ForwardedHeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				"Forwarded":       `for=5.6.7.8;proto=https;host="shop.example.com", for="[2001:db8::17]:4711", for=unknown`,
+				"X-Forwarded-For": "9.9.9.9",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "shop.example.com",
+		},
+		{
+			// 使用 Forwarded 的时候，客户端伪造的 X-Forwarded-* 都会被忽略
+			name:       "forwarded with injected x-forwarded",
+			forwarded:  ForwardedHeaderForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			header: map[string]string{
+				"Forwarded":         `for=6.6.6.6;host=evil.com, for=5.6.7.8;proto=https;host=shop.example.com`,
+				"X-Forwarded-For":   "9.9.9.9",
+				"X-Forwarded-Host":  "evil.com",
+				"X-Forwarded-Proto": "http",
+			},
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "shop.example.com",
+		},
+		{
+			name:       "forwarded ipv6 client",
+			forwarded:  ForwardedHeaderForwarded,
+			remoteAddr: "[2001:db8::1]:1234",
+			header:     map[string]string{"Forwarded": `for="[2002::17]:4711"`},
+			wantIP:     "2002::17",
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "x-real-ip",
+			forwarded:  ForwardedHeaderXRealIP,
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Real-IP": "5.6.7.8"},
+			tls:        true,
+			wantIP:     "5.6.7.8",
+			wantScheme: "https",
+			wantHost:   "example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://example.com/user", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			server := NewHTTPServer(ServerWithTrustedProxies("10.0.0.0/8", "192.168.1.1", "2001:db8::/32"),
+				ServerWithForwardedHeader(tc.forwarded))
+			ctx := &Context{Req: req, server: server}
+			assert.Equal(t, tc.wantIP, ctx.ClientIP())
+			assert.Equal(t, tc.wantScheme, ctx.Scheme())
+			assert.Equal(t, tc.wantHost, ctx.Host())
+		})
+	}
+}
+
+func TestServerWithTrustedProxies(t *testing.T) {
+	assert.Panics(t, func() {
+		ServerWithTrustedProxies("10.0.0.0/33")
+	})
+}
@@ -0,0 +1,251 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrUnsupportedMediaType 请求体的 Content-Type 没有对应的 Codec
+// 对应的是 415 响应
+var ErrUnsupportedMediaType = errors.New("web: 不支持的 Content-Type")
+
+// Codec 负责某一种媒体类型的编解码
+type Codec interface {
+	// Decode 把请求体解析到 val 上
+	Decode(req *http.Request, val any) error
+	// Encode 把 val 编码为这种媒体类型
+	Encode(val any) ([]byte, error)
+}
+
+// defaultCodecs 没有经过 HTTPServer 创建的 Context 使用这个
+var defaultCodecs = newCodecs()
+
+func newCodecs() map[string]Codec {
+	return map[string]Codec{
+		"application/json":                  JSONCodec{},
+		"application/xml":                   XMLCodec{},
+		"text/xml":                          XMLCodec{},
+		"application/x-www-form-urlencoded": FormCodec{},
+		"multipart/form-data":               MultipartCodec{MaxMemory: defaultMaxMemory},
+		"application/x-protobuf":            ProtobufCodec{},
+		"application/protobuf":              ProtobufCodec{},
+		"application/yaml":                  YAMLCodec{},
+		"application/x-yaml":                YAMLCodec{},
+	}
+}
+
+// ServerWithCodec 注册某种媒体类型的 Codec，会覆盖掉默认的
+// 例如 ServerWithCodec("application/json", JSONCodec{UseNumber: true})
+// 每次都是复制一份再修改，所以不会影响到别的 HTTPServer
+func ServerWithCodec(mediaType string, codec Codec) HTTPServerOption {
+	return func(server *HTTPServer) {
+		src := server.codecs
+		if src == nil {
+			src = defaultCodecs
+		}
+		codecs := make(map[string]Codec, len(src)+1)
+		for key, val := range src {
+			codecs[key] = val
+		}
+		codecs[mediaType] = codec
+		server.codecs = codecs
+	}
+}
+
+// codec 找到 mediaType 对应的 Codec
+// application/problem+json 这种带后缀的，找不到的时候会退化为 application/json
+func (c *Context) codec(mediaType string) (Codec, bool) {
+	codecs := defaultCodecs
+	if c.server != nil && c.server.codecs != nil {
+		codecs = c.server.codecs
+	}
+	if codec, ok := codecs[mediaType]; ok {
+		return codec, true
+	}
+	if idx := strings.LastIndexByte(mediaType, '+'); idx >= 0 {
+		codec, ok := codecs["application/"+mediaType[idx+1:]]
+		return codec, ok
+	}
+	return nil, false
+}
+
+// BindBody 按照 Content-Type 选择 Codec 来解析请求体
+// 找不到 Codec 的时候返回 ErrUnsupportedMediaType
+func (c *Context) BindBody(val any) error {
+	if c.Req.Body == nil {
+		return errors.New("web: body 为 nil")
+	}
+	contentType := c.Req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
+	}
+	codec, ok := c.codec(mediaType)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	return codec.Decode(c.Req, val)
+}
+
+// JSONCodec 同一个服务器上的所有接口使用同样的解析选项
+type JSONCodec struct {
+	// UseNumber 数字用 json.Number 来表示，否则默认是 float64
+	UseNumber bool
+	// DisallowUnknownFields 如果要是有一个未知的字段，就会报错
+	// 比如说你 User 只有 Name 和 Email 两个字段
+	// JSON 里面额外多了一个 Age 字段，那么就会报错
+	DisallowUnknownFields bool
+}
+
+func (j JSONCodec) Decode(req *http.Request, val any) error {
+	decoder := json.NewDecoder(req.Body)
+	if j.UseNumber {
+		decoder.UseNumber()
+	}
+	if j.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(val)
+}
+
+func (j JSONCodec) Encode(val any) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+type XMLCodec struct{}
+
+func (XMLCodec) Decode(req *http.Request, val any) error {
+	return xml.NewDecoder(req.Body).Decode(val)
+}
+
+func (XMLCodec) Encode(val any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FormCodec 按照 form 标签解析表单，val 也可以是 *url.Values
+type FormCodec struct{}
+
+func (FormCodec) Decode(req *http.Request, val any) error {
+	if err := req.ParseForm(); err != nil {
+		return err
+	}
+	return decodeForm(req.PostForm, val)
+}
+
+// Encode 只支持 url.Values
+func (FormCodec) Encode(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case url.Values:
+		return []byte(v.Encode()), nil
+	case map[string][]string:
+		return []byte(url.Values(v).Encode()), nil
+	}
+	return nil, fmt.Errorf("web: 表单只能编码 url.Values，但是输入是 %T", val)
+}
+
+// MultipartCodec 按照 form 标签解析 multipart 表单
+// 类型是 *multipart.FileHeader 或者 []*multipart.FileHeader 的字段会被设置为上传的文件
+type MultipartCodec struct {
+	// MaxMemory 最多在内存里面缓存多少数据，超过的部分会被写到临时文件
+	MaxMemory int64
+}
+
+var fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+
+func (m MultipartCodec) Decode(req *http.Request, val any) error {
+	if err := req.ParseMultipartForm(m.MaxMemory); err != nil {
+		return err
+	}
+	if err := decodeForm(req.MultipartForm.Value, val); err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		fd := rv.Type().Field(i)
+		key, ok := fd.Tag.Lookup("form")
+		if !ok || !fd.IsExported() {
+			continue
+		}
+		files := req.MultipartForm.File[key]
+		if len(files) == 0 {
+			continue
+		}
+		switch {
+		case fd.Type == fileHeaderType:
+			rv.Field(i).Set(reflect.ValueOf(files[0]))
+		case fd.Type.Kind() == reflect.Slice && fd.Type.Elem() == fileHeaderType:
+			rv.Field(i).Set(reflect.ValueOf(files))
+		}
+	}
+	return nil
+}
+
+func (MultipartCodec) Encode(val any) ([]byte, error) {
+	return nil, errors.New("web: 不支持编码 multipart 表单")
+}
+
+func decodeForm(form url.Values, val any) error {
+	if vals, ok := val.(*url.Values); ok {
+		*vals = form
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("web: 表单只能解析到结构体指针或者 *url.Values")
+	}
+	var errs BindErrors
+	bindStruct(rv.Elem(), "", func(source string, key string) ([]string, error) {
+		if source != "form" {
+			return nil, nil
+		}
+		return form[key], nil
+	}, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ProtobufCodec 使用 protobuf 的二进制格式，val 必须是 proto.Message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Decode(req *http.Request, val any) error {
+	msg, ok := val.(proto.Message)
+	if !ok {
+		return fmt.Errorf("web: %T 没有实现 proto.Message", val)
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (ProtobufCodec) Encode(val any) ([]byte, error) {
+	msg, ok := val.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T 没有实现 proto.Message", errUnsupportedValue, val)
+	}
+	return proto.Marshal(msg)
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecUser struct {
+	Name   string                `json:"name" xml:"name" form:"name"`
+	Age    int                   `json:"age" xml:"age" form:"age"`
+	Avatar *multipart.FileHeader `form:"avatar"`
+}
+
+func TestContext_BindBody(t *testing.T) {
+	multipartBody := func() (*bytes.Buffer, string) {
+		buf := &bytes.Buffer{}
+		w := multipart.NewWriter(buf)
+		_ = w.WriteField("name", "Tom")
+		_ = w.WriteField("age", "18")
+		fw, _ := w.CreateFormFile("avatar", "avatar.png")
+		_, _ = fw.Write([]byte("png"))
+		_ = w.Close()
+		return buf, w.FormDataContentType()
+	}
+	mbody, mtype := multipartBody()
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantVal     codecUser
+		wantErr     error
+	}{
+		{
+			name:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(`{"name":"Tom","age":18}`),
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "json suffix",
+			contentType: "application/vnd.api+json",
+			body:        []byte(`{"name":"Tom","age":18}`),
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "xml",
+			contentType: "application/xml",
+			body:        []byte(`<codecUser><name>Tom</name><age>18</age></codecUser>`),
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        []byte(`name=Tom&age=18`),
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "multipart",
+			contentType: mtype,
+			body:        mbody.Bytes(),
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "unsupported",
+			contentType: "application/octet-stream",
+			body:        []byte(`abc`),
+			wantErr:     ErrUnsupportedMediaType,
+		},
+		{
+			name:    "no content type",
+			body:    []byte(`abc`),
+			wantErr: ErrUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/user", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			ctx := &Context{Req: req}
+			var val codecUser
+			err := ctx.BindBody(&val)
+			if tc.wantErr != nil {
+				assert.True(t, errors.Is(err, tc.wantErr))
+				return
+			}
+			assert.NoError(t, err)
+			if val.Avatar != nil {
+				assert.Equal(t, "avatar.png", val.Avatar.Filename)
+				val.Avatar = nil
+			}
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestContext_BindBodyProtobuf(t *testing.T) {
+	data, err := proto.Marshal(wrapperspb.String("Tom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/user", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	ctx := &Context{Req: req}
+	val := &wrapperspb.StringValue{}
+	assert.NoError(t, ctx.BindBody(val))
+	assert.Equal(t, "Tom", val.Value)
+
+	// 不是 proto.Message
+	req = httptest.NewRequest(http.MethodPost, "/user", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	ctx = &Context{Req: req}
+	assert.Error(t, ctx.BindBody(&codecUser{}))
+}
+
+func TestServerWithCodec(t *testing.T) {
+	server := NewHTTPServer(ServerWithCodec("application/json", JSONCodec{
+		UseNumber:             true,
+		DisallowUnknownFields: true,
+	}))
+	var errs []error
+	var vals []map[string]any
+	server.Post("/user", func(ctx *Context) {
+		val := map[string]any{}
+		errs = append(errs, ctx.BindJSON(&val))
+		vals = append(vals, val)
+	})
+	server.Post("/strict", func(ctx *Context) {
+		var val codecUser
+		errs = append(errs, ctx.BindBody(&val))
+	})
+	for _, path := range []string{"/user", "/strict"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"name":"Tom","age":18,"email":"tom@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.NoError(t, errs[0])
+	assert.Equal(t, json.Number("18"), vals[0]["age"])
+	assert.Error(t, errs[1])
+	// 不会修改默认的 Codec
+	assert.Equal(t, JSONCodec{}, defaultCodecs["application/json"])
+}
+
+func TestFormCodec_Encode(t *testing.T) {
+	data, err := FormCodec{}.Encode(url.Values{"name": []string{"Tom"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "name=Tom", string(data))
+	_, err = FormCodec{}.Encode(codecUser{})
+	assert.Error(t, err)
+}
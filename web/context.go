@@ -1,36 +1,204 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 )
 
+// 确保 Context 可以直接作为 context.Context 使用
+// 这样用户可以直接把它传给 database/sql, http.Client 之类的下游调用
+var _ context.Context = &Context{}
+
 type Context struct {
 	Req  *http.Request
 
-	// Resp 如果用户直接使用这个
-	// 那么他们就绕开了 RespData 和 RespStatusCode 两个
-	// 那么部分 middleware 无法运作
+	// Resp 是包装过的 ResponseWriter
+	// 缓冲模式下，用户直接写 Resp 也会被记录到 RespData 和 RespStatusCode 里面
+	// 流式模式下，写入会直接发送出去，但是响应码和写入的字节数依旧会被记录下来
 	Resp http.ResponseWriter
+	rw   *responseWriter
 
 	// 这个主要是为了个 middleware 读写用的
 	RespData []byte
 	RespStatusCode int
 
-	// Ctx context.Context
-
 	PathParams map[string]string
 
 	queryValues url.Values
 
 	MatchedRoute string
 
+	// HandlerErr 处理请求过程中出现的错误
+	// handler 可以把错误设置在这里，交给 errhdl 之类的 middleware 统一转换为响应
+	HandlerErr error
+
+	// 创建这个 Context 的服务器，用来获取服务器级别的配置
+	server *HTTPServer
+
+	// keys 是在处理请求过程中，middleware 和用户设置的数据
+	// 比如说登录用户，租户，request id
+	// 用户可能会在自己开的 goroutine 里面读写，所以要加锁
+	keys      map[string]any
+	keysMutex sync.RWMutex
+
+	// 框架开始处理请求的时间
+	startTime time.Time
+	// 通过 Timing 记录的各个阶段的耗时
+	timings timings
+	// 通过 AfterFlush 注册的回调
+	afterFlush []func()
+
 	// cookieSameSite http.SameSite
 }
 
+// Deadline 委托给请求的 context
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	return c.reqContext().Deadline()
+}
+
+// Done 委托给请求的 context，客户端断开连接的时候会被关闭
+func (c *Context) Done() <-chan struct{} {
+	return c.reqContext().Done()
+}
+
+// Err 委托给请求的 context
+func (c *Context) Err() error {
+	return c.reqContext().Err()
+}
+
+// Value 优先查找通过 Set 设置的数据，找不到再去请求的 context 里面找
+// 所以 middleware 用 Set 放进来的数据，在下游的调用里面也能拿到
+func (c *Context) Value(key any) any {
+	if k, ok := key.(string); ok {
+		if val, ok := c.Get(k); ok {
+			return val
+		}
+	}
+	return c.reqContext().Value(key)
+}
+
+// reqContext 每次都从 Req 里面拿，
+// 因为 middleware 可能会通过 ctx.Req = ctx.Req.WithContext(...) 替换掉它
+func (c *Context) reqContext() context.Context {
+	if c.Req == nil {
+		return context.Background()
+	}
+	return c.Req.Context()
+}
+
+// Set 设置一个请求级别的数据，一般是 middleware 用来把数据传递给后面的 handler
+func (c *Context) Set(key string, val any) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]any, 4)
+	}
+	c.keys[key] = val
+}
+
+// Get 取出通过 Set 设置的数据
+func (c *Context) Get(key string) (any, bool) {
+	c.keysMutex.RLock()
+	defer c.keysMutex.RUnlock()
+	val, ok := c.keys[key]
+	return val, ok
+}
+
+// MustGet 取出通过 Set 设置的数据，如果没有就 panic
+// 适合那些前面的 middleware 一定会设置的数据，比如说登录用户
+func (c *Context) MustGet(key string) any {
+	val, ok := c.Get(key)
+	if !ok {
+		panic(fmt.Sprintf("web: key %s 不存在", key))
+	}
+	return val
+}
+
+// GetAs 取出通过 Set 设置的数据，并且转换为 T
+// 方法不能有类型参数，所以只能做成函数
+// 如果数据不存在，或者类型不对，第二个返回值是 false
+func GetAs[T any](c *Context, key string) (T, bool) {
+	val, ok := c.Get(key)
+	if !ok {
+		var t T
+		return t, false
+	}
+	res, ok := val.(T)
+	return res, ok
+}
+
+// MustGetAs 类似于 GetAs，但是数据不存在或者类型不对的时候会 panic
+func MustGetAs[T any](c *Context, key string) T {
+	val := c.MustGet(key)
+	res, ok := val.(T)
+	if !ok {
+		panic(fmt.Sprintf("web: key %s 的类型是 %T，不是 %T", key, val, res))
+	}
+	return res
+}
+
+// RespHeader 返回还没有发送的响应头
+// 在 flashResp 之前，middleware 都可以通过它来修改响应头
+func (c *Context) RespHeader() http.Header {
+	if c.rw == nil {
+		if c.Resp != nil {
+			return c.Resp.Header()
+		}
+		// 没有 Resp 的 Context，一般是单元测试里面创建的，那就只缓存下来
+		c.rw = newResponseWriter(c, nil)
+	}
+	return c.rw.Header()
+}
+
+// RequestID 请求的 X-Request-Id
+// 优先使用 middleware 设置在响应上的，比如说生成了新的 ID，其次是客户端带过来的
+func (c *Context) RequestID() string {
+	if id := c.RespHeader().Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return c.Req.Header.Get("X-Request-Id")
+}
+
+// RespMode 返回当前的响应模式
+func (c *Context) RespMode() RespMode {
+	if c.rw == nil {
+		// 没有经过 HTTPServer 创建的 Context，写入都是直接发送出去的
+		return RespModeStreaming
+	}
+	return c.rw.mode
+}
+
+// SetRespMode 切换响应模式
+// 响应头一旦发送出去，就不能再切换回缓冲模式了
+// RespModeHijacked 只能通过 Hijack 进入
+func (c *Context) SetRespMode(mode RespMode) {
+	if c.rw == nil || c.rw.committed || mode == RespModeHijacked {
+		return
+	}
+	c.rw.mode = mode
+}
+
+// RespCommitted 响应头是否已经发送出去了
+// 已经发送了的话，再修改 RespStatusCode 和响应头就没有意义了
+func (c *Context) RespCommitted() bool {
+	return c.rw != nil && c.rw.committed
+}
+
+// RespSize 响应体的大小，包括已经发送出去的部分和还缓存在 RespData 里面的部分
+func (c *Context) RespSize() int {
+	if c.rw == nil {
+		return len(c.RespData)
+	}
+	return c.rw.size + len(c.RespData)
+}
+
 // 用户每次都得自己检测是不是 500，然后调这个方法
 // func (c *Context) ErrPage() {
 //
@@ -55,10 +223,7 @@ func (c *Context) RespJSON(status int, val any) error {
 	if err != nil {
 		return err
 	}
-	// c.Resp.Header().Set("Content-Type", "application/json")
-	// c.Resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	c.RespData = data
-	c.RespStatusCode = status
+	c.Data(status, "application/json; charset=utf-8", data)
 	return nil
 }
 
@@ -70,20 +235,10 @@ func (c *Context) BindJSON(val any) error {
 	if c.Req.Body == nil {
 		return errors.New("web: body 为 nil")
 	}
-	// bs, _:= io.ReadAll(c.Req.Body)
-	// json.Unmarshal(bs, val)
-	decoder := json.NewDecoder(c.Req.Body)
-	// useNumber => 数字就是用 Number 来表示
-	// 否则默认是 float64
-	// if jsonUseNumber {
-	// 	decoder.UseNumber()
-	// }
-
-	// 如果要是有一个未知的字段，就会报错
-	// 比如说你 User 只有 Name 和 Email 两个字段
-	// JSON 里面额外多了一个 Age 字段，那么就会报错
-	// decoder.DisallowUnknownFields()
-	return decoder.Decode(val)
+	// 要不要 UseNumber，要不要 DisallowUnknownFields
+	// 都是服务器级别的配置，参考 JSONCodec
+	codec, _ := c.codec("application/json")
+	return codec.Decode(c.Req, val)
 }
 
 // FormValue(key1)
@@ -97,6 +252,7 @@ func (c *Context) FormValue(key string) (string, error) {
 }
 
 // QueryValueV2[int]("key1") 希望拿到一个 int 返回值
+// 方法不能有类型参数，所以只能做成函数，参考 QueryAs
 // func (c *Context) QueryValueV2[T any](key string) (T, error) {
 //
 // }
@@ -110,7 +266,7 @@ func (c *Context) QueryValue(key string) (string, error) {
 
 	vals, ok := c.queryValues[key]
 	if !ok {
-		return "", errors.New("web: key 不存在")
+		return "", ErrKeyNotFound
 	}
 	return vals[0], nil
 
@@ -128,7 +284,7 @@ func (c *Context) QueryValueV1(key string) StringValue {
 	vals, ok := c.queryValues[key]
 	if !ok {
 		return StringValue{
-			err: errors.New("web: key 不存在"),
+			err: ErrKeyNotFound,
 		}
 	}
 	return StringValue{
@@ -144,7 +300,7 @@ func (c *Context) PathValueV1(key string) StringValue {
 	val, ok := c.PathParams[key]
 	if !ok {
 		return StringValue{
-			err: errors.New("web: key 不存在"),
+			err: ErrKeyNotFound,
 		}
 	}
 	return StringValue{
@@ -155,7 +311,7 @@ func (c *Context) PathValueV1(key string) StringValue {
 func (c *Context) PathValue(key string) (string, error) {
 	val, ok := c.PathParams[key]
 	if !ok {
-		return "", errors.New("web: key 不存在")
+		return "", ErrKeyNotFound
 	}
 	return val, nil
 }
@@ -178,6 +334,7 @@ func (s StringValue) AsInt64() (int64, error) {
 	return strconv.ParseInt(s.val, 10, 64)
 }
 
+// 同样的，这里也只能做成函数，参考 As
 // func (s StringValue[T]) As() (T, error) {
 // }
 
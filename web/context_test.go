@@ -0,0 +1,93 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestContext_Context(t *testing.T) {
+	reqCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	reqCtx = context.WithValue(reqCtx, ctxKey{}, "from request")
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Req: req}
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	wantDeadline, _ := reqCtx.Deadline()
+	assert.Equal(t, wantDeadline, deadline)
+
+	ctx.Set("user", "Tom")
+	assert.Equal(t, "Tom", ctx.Value("user"))
+	assert.Equal(t, "from request", ctx.Value(ctxKey{}))
+	assert.Nil(t, ctx.Value("tenant"))
+
+	// 传给下游的 http.Client 之后，依旧能够拿到数据
+	downstream, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/order", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Tom", downstream.Context().Value("user"))
+
+	// middleware 替换了 Req 之后，委托给新的 context
+	ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), ctxKey{}, "replaced"))
+	assert.Equal(t, "replaced", ctx.Value(ctxKey{}))
+
+	assert.Nil(t, ctx.Err())
+	cancel()
+	<-ctx.Done()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestContext_Get(t *testing.T) {
+	ctx := &Context{}
+	_, ok := ctx.Get("user")
+	assert.False(t, ok)
+	assert.Panics(t, func() {
+		ctx.MustGet("user")
+	})
+
+	ctx.Set("user", "Tom")
+	ctx.Set("tenant", 123)
+	val, ok := ctx.Get("user")
+	assert.True(t, ok)
+	assert.Equal(t, "Tom", val)
+
+	user, ok := GetAs[string](ctx, "user")
+	assert.True(t, ok)
+	assert.Equal(t, "Tom", user)
+
+	// 类型不对
+	_, ok = GetAs[string](ctx, "tenant")
+	assert.False(t, ok)
+	assert.Equal(t, 123, MustGetAs[int](ctx, "tenant"))
+	assert.Panics(t, func() {
+		MustGetAs[string](ctx, "tenant")
+	})
+
+	// 没有 Req 的时候也可以作为 context.Context 使用
+	assert.Nil(t, ctx.Err())
+	assert.Equal(t, "Tom", ctx.Value("user"))
+}
+
+func TestContext_RequestID(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Req: req}
+	assert.Equal(t, "", ctx.RequestID())
+	req.Header.Set("X-Request-Id", "from-client")
+	assert.Equal(t, "from-client", ctx.RequestID())
+	// middleware 生成的优先
+	ctx.RespHeader().Set("X-Request-Id", "generated")
+	assert.Equal(t, "generated", ctx.RequestID())
+}
@@ -0,0 +1,141 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HandleFuncE 可以返回 error 的 HandleFunc
+// 返回的 error 会被放到 Context.HandlerErr 上，然后交给 ErrorHandler 转换为响应
+type HandleFuncE func(ctx *Context) error
+
+// ErrorHandler 把 HandleFuncE 返回的 error 转换为响应
+type ErrorHandler func(ctx *Context, err error)
+
+// HTTPError 带有响应码的错误
+// 业务代码可以直接返回它，也可以把它包在别的 error 里面返回
+type HTTPError struct {
+	Status int `json:"-"`
+	// Code 业务错误码，比如说 user_not_found
+	Code string `json:"code,omitempty"`
+	// Message 返回给客户端的信息
+	Message string `json:"message"`
+	// Cause 真正的原因，只用来记录日志，不会返回给客户端
+	Cause error `json:"-"`
+}
+
+// NewHTTPError message 为空的话，使用 http.StatusText(status)
+func NewHTTPError(status int, code string, message string) *HTTPError {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &HTTPError{Status: status, Code: code, Message: message}
+}
+
+// WithCause 返回一个带有 cause 的副本
+func (e *HTTPError) WithCause(cause error) *HTTPError {
+	res := *e
+	res.Cause = cause
+	return &res
+}
+
+func (e *HTTPError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("web: %d %s: %v", e.Status, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("web: %d %s", e.Status, e.Message)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+// ServerWithErrorHandler 设置 ErrorHandler，默认是 DefaultErrorHandler
+func ServerWithErrorHandler(handler ErrorHandler) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.errHandler = handler
+	}
+}
+
+// HandleE 注册可以返回 error 的 handler
+func (h *HTTPServer) HandleE(method string, path string, handleFunc HandleFuncE, mdls ...Middleware) {
+	h.addRoute(method, path, h.wrapE(handleFunc), mdls...)
+}
+
+func (h *HTTPServer) GetE(path string, handleFunc HandleFuncE, mdls ...Middleware) {
+	h.HandleE(http.MethodGet, path, handleFunc, mdls...)
+}
+
+func (h *HTTPServer) PostE(path string, handleFunc HandleFuncE, mdls ...Middleware) {
+	h.HandleE(http.MethodPost, path, handleFunc, mdls...)
+}
+
+// wrapE 转换为 HandleFunc
+// error 会被放到 HandlerErr 上，所以 middleware 在 next 返回之后就能拿到，
+// 比如说用来设置 span 的状态，或者统计错误数量
+func (h *HTTPServer) wrapE(handleFunc HandleFuncE) HandleFunc {
+	return func(ctx *Context) {
+		err := handleFunc(ctx)
+		if err == nil {
+			return
+		}
+		ctx.HandlerErr = err
+		ctx.errorHandler()(ctx, err)
+	}
+}
+
+// errorHandler 创建 Context 的服务器上的 ErrorHandler，没有的话就是 DefaultErrorHandler
+func (c *Context) errorHandler() ErrorHandler {
+	if c.server != nil && c.server.errHandler != nil {
+		return c.server.errHandler
+	}
+	return DefaultErrorHandler
+}
+
+// DefaultErrorHandler 默认的 ErrorHandler
+// HTTPError 使用它的响应码和信息，框架自身的错误，比如说绑定失败，转换为对应的 4xx 响应，
+// 其它的都是 500，并且不会把 error 的内容返回给客户端
+func DefaultErrorHandler(ctx *Context, err error) {
+	if ctx.RespCommitted() {
+		// 流式响应已经发送出去了，没有办法再修改
+		return
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = &HTTPError{Status: ErrorStatus(err)}
+		if httpErr.Status < 500 {
+			httpErr.Message = err.Error()
+		}
+	}
+	resp := *httpErr
+	if resp.Status == 0 {
+		resp.Status = http.StatusInternalServerError
+	}
+	if resp.Message == "" {
+		resp.Message = http.StatusText(resp.Status)
+	}
+	_ = ctx.RespJSON(resp.Status, resp)
+}
+
+// ErrorStatus 错误对应的响应码，不认识的错误都是 500
+func ErrorStatus(err error) int {
+	var httpErr *HTTPError
+	var verrs ValidationErrors
+	var berrs BindErrors
+	switch {
+	case errors.As(err, &httpErr) && httpErr.Status != 0:
+		return httpErr.Status
+	case errors.As(err, &verrs):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &berrs):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrNotAcceptable):
+		return http.StatusNotAcceptable
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrEmptyValue):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
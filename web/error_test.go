@@ -0,0 +1,130 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPServer_HandleE(t *testing.T) {
+	errUserNotFound := NewHTTPError(http.StatusNotFound, "user_not_found", "用户不存在")
+	var observed error
+	observer := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			observed = ctx.HandlerErr
+		}
+	}
+	server := NewHTTPServer(ServerWithMiddleware(observer))
+	server.GetE("/ok", func(ctx *Context) error {
+		return ctx.RespJSONOK(map[string]string{"name": "Tom"})
+	})
+	server.GetE("/user", func(ctx *Context) error {
+		return errUserNotFound
+	})
+	server.GetE("/wrapped", func(ctx *Context) error {
+		return fmt.Errorf("查询用户: %w", errUserNotFound.WithCause(errors.New("sql: no rows")))
+	})
+	server.GetE("/internal", func(ctx *Context) error {
+		return errors.New("数据库密码错误")
+	})
+	server.PostE("/bind", func(ctx *Context) error {
+		return ctx.Bind(&struct {
+			Age int `query:"age"`
+		}{})
+	})
+	server.GetE("/json", func(ctx *Context) error {
+		// 编码失败的错误以前只能被丢掉
+		return ctx.RespJSONOK(make(chan int))
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+
+		wantCode int
+		wantBody string
+		wantErr  string
+	}{
+		{
+			name:     "ok",
+			path:     "/ok",
+			wantCode: http.StatusOK,
+			wantBody: `{"name":"Tom"}`,
+		},
+		{
+			name:     "http error",
+			path:     "/user",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"code":"user_not_found","message":"用户不存在"}`,
+			wantErr:  "web: 404 用户不存在",
+		},
+		{
+			name:     "wrapped",
+			path:     "/wrapped",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"code":"user_not_found","message":"用户不存在"}`,
+			wantErr:  "查询用户: web: 404 用户不存在: sql: no rows",
+		},
+		{
+			// 不会把内部的错误暴露给客户端
+			name:     "internal",
+			path:     "/internal",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"message":"Internal Server Error"}`,
+			wantErr:  "数据库密码错误",
+		},
+		{
+			name:     "bind",
+			method:   http.MethodPost,
+			path:     "/bind?age=abc",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"message":"web: 字段 Age 绑定失败, query:\"age\" 的值 \"abc\" 不合法: strconv.ParseInt: parsing \"abc\": invalid syntax"}`,
+			wantErr:  `web: 字段 Age 绑定失败, query:"age" 的值 "abc" 不合法: strconv.ParseInt: parsing "abc": invalid syntax`,
+		},
+		{
+			name:     "json",
+			path:     "/json",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"message":"Internal Server Error"}`,
+			wantErr:  "json: unsupported type: chan int",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			observed = nil
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(method, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			if tc.wantErr == "" {
+				assert.Nil(t, observed)
+				return
+			}
+			assert.EqualError(t, observed, tc.wantErr)
+		})
+	}
+}
+
+func TestServerWithErrorHandler(t *testing.T) {
+	server := NewHTTPServer(ServerWithErrorHandler(func(ctx *Context, err error) {
+		ctx.String(ErrorStatus(err), "出错了")
+	}))
+	server.GetE("/user", func(ctx *Context) error {
+		return NewHTTPError(http.StatusForbidden, "", "")
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, "出错了", recorder.Body.String())
+}
@@ -0,0 +1,329 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrFileTooLarge       = errors.New("web: 文件太大")
+	ErrTooManyFiles       = errors.New("web: 文件数量太多")
+	ErrFileTypeNotAllowed = errors.New("web: 不允许的文件类型")
+	ErrNoFile             = errors.New("web: 没有上传文件")
+	// ErrMalformedMultipart 请求体不是合法的 multipart 格式
+	ErrMalformedMultipart = errors.New("web: multipart 格式不正确")
+)
+
+// sniffLen http.DetectContentType 最多只看前 512 个字节
+const sniffLen = 512
+
+// FileStore 文件存储，可以是本地磁盘，也可以是 OSS 之类的
+type FileStore interface {
+	// Save 保存 r 里面的数据，filename 是用户上传的文件名，仅供参考
+	// 返回值是保存之后的路径，后面 Delete 的时候会用到
+	// r 读取失败的时候，Save 要负责清理掉已经写入的数据
+	Save(ctx context.Context, filename string, r io.Reader) (string, error)
+	Delete(ctx context.Context, path string) error
+}
+
+// UploadedFile 上传成功的文件的元数据
+type UploadedFile struct {
+	Field    string `json:"field"`
+	Filename string `json:"filename"`
+	// ContentType 根据文件内容推断出来的，而不是请求里面声明的
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// Hash sha256 的十六进制编码
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+}
+
+// UploadProgress 上传进度
+type UploadProgress struct {
+	Filename string
+	// Index 第几个文件，从 0 开始
+	Index int
+	// Written 这个文件已经写入了多少字节
+	Written int64
+}
+
+// FileUploader 处理文件上传
+// 文件是一边读一边写到 FileStore 里面的，不会把整个文件放在内存里面
+type FileUploader struct {
+	fileField    string
+	maxSize      int64
+	maxFiles     int
+	allowedTypes []string
+	store        FileStore
+	onProgress   func(p UploadProgress)
+}
+
+// NewFileUploader fileField 是表单里面文件的字段名
+// 默认保存到临时目录，单个文件最大 32M，最多 1 个文件，不限制类型
+func NewFileUploader(fileField string) *FileUploader {
+	return &FileUploader{
+		fileField: fileField,
+		maxSize:   defaultMaxMemory,
+		maxFiles:  1,
+		store:     &LocalFileStore{Dir: os.TempDir()},
+	}
+}
+
+// MaxSize 单个文件的最大字节数
+func (u *FileUploader) MaxSize(size int64) *FileUploader {
+	u.maxSize = size
+	return u
+}
+
+// MaxFiles 一次请求最多上传多少个文件
+func (u *FileUploader) MaxFiles(n int) *FileUploader {
+	u.maxFiles = n
+	return u
+}
+
+// AllowTypes 允许的 MIME 类型，比如说 image/png，也可以是 image/*
+// 类型是根据文件内容推断的，不相信请求里面的 Content-Type
+func (u *FileUploader) AllowTypes(types ...string) *FileUploader {
+	u.allowedTypes = append(u.allowedTypes, types...)
+	return u
+}
+
+// Store 设置文件存储
+func (u *FileUploader) Store(store FileStore) *FileUploader {
+	u.store = store
+	return u
+}
+
+// OnProgress 每次写入数据之后都会回调
+func (u *FileUploader) OnProgress(fn func(p UploadProgress)) *FileUploader {
+	u.onProgress = fn
+	return u
+}
+
+// Handle 上传成功的话，返回所有文件的元数据
+// 失败的话，把错误转换为带响应码的 HTTPError，放到 HandlerErr 里面，再交给服务器的 ErrorHandler。
+// 只有 4xx 的错误信息会返回给客户端，5xx 的错误里面可能有服务器上的路径
+func (u *FileUploader) Handle() HandleFunc {
+	return func(ctx *Context) {
+		files, err := u.Upload(ctx)
+		if err != nil {
+			status := uploadErrStatus(err)
+			httpErr := NewHTTPError(status, "", "")
+			if status < 500 {
+				httpErr.Message = err.Error()
+			}
+			ctx.HandlerErr = httpErr.WithCause(err)
+			ctx.errorHandler()(ctx, ctx.HandlerErr)
+			return
+		}
+		_ = ctx.RespJSON(http.StatusOK, files)
+	}
+}
+
+func uploadErrStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrTooManyFiles), errors.Is(err, ErrNoFile), errors.Is(err, ErrMalformedMultipart),
+		errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Upload 读取请求里面的文件并且保存起来
+// 任何一个文件失败，已经保存的文件都会被删掉
+func (u *FileUploader) Upload(ctx *Context) (files []*UploadedFile, err error) {
+	reader, err := ctx.Req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, f := range files {
+			_ = u.store.Delete(ctx, f.Path)
+		}
+		files = nil
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("%w: %v", ErrMalformedMultipart, err)
+		}
+		if part.FormName() != u.fileField || part.FileName() == "" {
+			_ = part.Close()
+			continue
+		}
+		if len(files) >= u.maxFiles {
+			_ = part.Close()
+			return files, ErrTooManyFiles
+		}
+		f, err := u.save(ctx, part.FileName(), len(files), part)
+		_ = part.Close()
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, ErrNoFile
+	}
+	return files, nil
+}
+
+func (u *FileUploader) save(ctx *Context, filename string, index int, r io.Reader) (*UploadedFile, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !u.allowed(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrFileTypeNotAllowed, contentType)
+	}
+	ur := &uploadReader{
+		r:        io.MultiReader(bytes.NewReader(head), r),
+		hash:     sha256.New(),
+		maxSize:  u.maxSize,
+		progress: u.onProgress,
+		filename: filename,
+		index:    index,
+	}
+	path, err := u.store.Save(ctx, filename, ur)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadedFile{
+		Field:       u.fileField,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        ur.written,
+		Hash:        hex.EncodeToString(ur.hash.Sum(nil)),
+		Path:        path,
+	}, nil
+}
+
+func (u *FileUploader) allowed(contentType string) bool {
+	if len(u.allowedTypes) == 0 {
+		return true
+	}
+	for _, typ := range u.allowedTypes {
+		if typ == contentType {
+			return true
+		}
+		if strings.HasSuffix(typ, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(typ, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadReader 一边读一边计算哈希，统计大小，并且回调进度
+type uploadReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	maxSize  int64
+	written  int64
+	progress func(p UploadProgress)
+	filename string
+	index    int
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.written += int64(n)
+		if r.written > r.maxSize {
+			return 0, ErrFileTooLarge
+		}
+		r.hash.Write(p[:n])
+		if r.progress != nil {
+			r.progress(UploadProgress{Filename: r.filename, Index: r.index, Written: r.written})
+		}
+	}
+	return n, err
+}
+
+// LocalFileStore 保存到本地磁盘
+// 文件名是随机生成的，只保留原始文件名的后缀，所以不用担心路径穿越之类的问题
+type LocalFileStore struct {
+	Dir string
+}
+
+func (s *LocalFileStore) Save(ctx context.Context, filename string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+	name, err := safeFilename(filename)
+	if err != nil {
+		return "", err
+	}
+	// 先写到临时文件，成功之后再改名，这样就不会留下写了一半的文件
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	path := filepath.Join(s.Dir, name)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *LocalFileStore) Delete(ctx context.Context, path string) error {
+	// 只允许删除 Dir 下面的文件
+	rel, err := filepath.Rel(s.Dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("web: %s 不在 %s 下面", path, s.Dir)
+	}
+	return os.Remove(path)
+}
+
+// safeFilename 随机的文件名加上原始文件名的后缀
+// 后缀只保留字母和数字
+func safeFilename(filename string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(buf)
+	ext := strings.ToLower(filepath.Ext(filepath.Base(filename)))
+	for _, c := range strings.TrimPrefix(ext, ".") {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return name, nil
+		}
+	}
+	if len(ext) > 1 {
+		name += ext
+	}
+	return name, nil
+}
@@ -0,0 +1,197 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader 足够让 http.DetectContentType 认为是 image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+type uploadFile struct {
+	field    string
+	filename string
+	data     []byte
+}
+
+func newUploadReq(t *testing.T, files ...uploadFile) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("desc", "avatar"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		w, err := writer.CreateFormFile(f.field, f.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/upload", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestFileUploader_Upload(t *testing.T) {
+	png := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{1}, 100)...)
+	pngHash := sha256.Sum256(png)
+	testCases := []struct {
+		name     string
+		uploader func(dir string) *FileUploader
+		files    []uploadFile
+
+		wantFiles []*UploadedFile
+		wantErr   error
+	}{
+		{
+			name: "success",
+			uploader: func(dir string) *FileUploader {
+				return NewFileUploader("file").MaxFiles(2).AllowTypes("image/*").
+					Store(&LocalFileStore{Dir: dir})
+			},
+			files: []uploadFile{
+				{field: "file", filename: "a.PNG", data: png},
+				// 别的字段会被忽略
+				{field: "other", filename: "b.png", data: png},
+				{field: "file", filename: "../../c.png", data: png},
+			},
+			wantFiles: []*UploadedFile{
+				{Field: "file", Filename: "a.PNG", ContentType: "image/png", Size: 108,
+					Hash: hex.EncodeToString(pngHash[:])},
+				{Field: "file", Filename: "c.png", ContentType: "image/png", Size: 108,
+					Hash: hex.EncodeToString(pngHash[:])},
+			},
+		},
+		{
+			// 请求里面声明的 Content-Type 是不可信的
+			name: "type not allowed",
+			uploader: func(dir string) *FileUploader {
+				return NewFileUploader("file").AllowTypes("image/png").Store(&LocalFileStore{Dir: dir})
+			},
+			files:   []uploadFile{{field: "file", filename: "a.png", data: []byte("hello")}},
+			wantErr: ErrFileTypeNotAllowed,
+		},
+		{
+			name: "too large",
+			uploader: func(dir string) *FileUploader {
+				return NewFileUploader("file").MaxFiles(2).MaxSize(100).Store(&LocalFileStore{Dir: dir})
+			},
+			files: []uploadFile{
+				{field: "file", filename: "a.txt", data: []byte("hello")},
+				{field: "file", filename: "b.png", data: png},
+			},
+			wantErr: ErrFileTooLarge,
+		},
+		{
+			name: "too many files",
+			uploader: func(dir string) *FileUploader {
+				return NewFileUploader("file").Store(&LocalFileStore{Dir: dir})
+			},
+			files: []uploadFile{
+				{field: "file", filename: "a.txt", data: []byte("hello")},
+				{field: "file", filename: "b.txt", data: []byte("world")},
+			},
+			wantErr: ErrTooManyFiles,
+		},
+		{
+			name: "no file",
+			uploader: func(dir string) *FileUploader {
+				return NewFileUploader("file").Store(&LocalFileStore{Dir: dir})
+			},
+			wantErr: ErrNoFile,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := &Context{Req: newUploadReq(t, tc.files...)}
+			files, err := tc.uploader(dir).Upload(ctx)
+			assert.ErrorIs(t, err, tc.wantErr)
+			entries, readErr := os.ReadDir(dir)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if err != nil {
+				// 失败的时候，已经保存的文件和临时文件都要被清理掉
+				assert.Empty(t, entries)
+				return
+			}
+			assert.Equal(t, len(tc.wantFiles), len(entries))
+			for i, f := range files {
+				assert.Equal(t, dir, filepath.Dir(f.Path))
+				assert.True(t, strings.HasSuffix(f.Path, ".png"))
+				f.Path = ""
+				assert.Equal(t, tc.wantFiles[i], f)
+			}
+		})
+	}
+}
+
+func TestFileUploader_Handle(t *testing.T) {
+	dir := t.TempDir()
+	var progress []UploadProgress
+	handler := NewFileUploader("file").Store(&LocalFileStore{Dir: dir}).
+		OnProgress(func(p UploadProgress) {
+			progress = append(progress, p)
+		}).Handle()
+
+	ctx := &Context{Req: newUploadReq(t, uploadFile{field: "file", filename: "a.txt", data: []byte("hello")})}
+	handler(ctx)
+	assert.Equal(t, http.StatusOK, ctx.RespStatusCode)
+	var files []*UploadedFile
+	if err := json.Unmarshal(ctx.RespData, &files); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(files))
+	data, err := os.ReadFile(files[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello", string(data))
+	assert.NotEmpty(t, progress)
+	assert.Equal(t, int64(5), progress[len(progress)-1].Written)
+
+	ctx = &Context{Req: newUploadReq(t)}
+	handler(ctx)
+	assert.Equal(t, http.StatusBadRequest, ctx.RespStatusCode)
+	assert.ErrorIs(t, ctx.HandlerErr, ErrNoFile)
+	assert.Equal(t, `{"message":"web: 没有上传文件"}`, string(ctx.RespData))
+
+	// 格式不对是客户端的问题
+	req := newUploadReq(t, uploadFile{field: "file", filename: "a.txt", data: []byte("hello")})
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=wrong")
+	ctx = &Context{Req: req}
+	handler(ctx)
+	assert.Equal(t, http.StatusBadRequest, ctx.RespStatusCode)
+	assert.ErrorIs(t, ctx.HandlerErr, ErrMalformedMultipart)
+
+	// 保存失败是 500，不能把服务器上的路径返回给客户端
+	file := filepath.Join(dir, "file")
+	if err = os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	handler = NewFileUploader("file").Store(&LocalFileStore{Dir: filepath.Join(file, "sub")}).Handle()
+	ctx = &Context{Req: newUploadReq(t, uploadFile{field: "file", filename: "a.txt", data: []byte("hello")})}
+	handler(ctx)
+	assert.Equal(t, http.StatusInternalServerError, ctx.RespStatusCode)
+	assert.Equal(t, `{"message":"Internal Server Error"}`, string(ctx.RespData))
+}
@@ -0,0 +1,441 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrHubClosed Hub 已经关闭
+	ErrHubClosed = errors.New("web: hub 已经关闭")
+	// ErrSlowConsumer 订阅者消费得太慢，被断开了
+	ErrSlowConsumer = errors.New("web: 订阅者消费太慢，已断开")
+)
+
+// HubMessage 发布到 Hub 上的消息
+type HubMessage struct {
+	Topic string
+	Data  []byte
+}
+
+// Broker 负责把消息投递到所有订阅了 topic 的节点
+// 单机用 MemoryBroker 就可以，多节点的时候可以基于 Redis 之类的实现
+type Broker interface {
+	// Publish 发布消息，所有订阅了 msg.Topic 的 handler 都会收到
+	Publish(ctx context.Context, msg HubMessage) error
+	// Subscribe 订阅 topic，返回值用来取消订阅
+	Subscribe(topic string, handler func(msg HubMessage)) (func(), error)
+}
+
+// SlowConsumerPolicy 订阅者的缓冲区满了之后怎么办
+type SlowConsumerPolicy uint8
+
+const (
+	// DropNewest 丢弃新的消息，这是默认的策略
+	DropNewest SlowConsumerPolicy = iota
+	// DropOldest 丢弃缓冲区里面最老的消息，适合只关心最新状态的场景
+	DropOldest
+	// Disconnect 直接断开订阅者，适合不能容忍丢消息的场景，让客户端重连之后重新同步
+	Disconnect
+)
+
+// Hub 按照 topic 把消息推送给订阅者，比如说 SSE 和 websocket 连接
+// topic 就是一个字符串，比如说 order:123 或者 tenant:acme
+type Hub struct {
+	broker     Broker
+	bufferSize int
+	policy     SlowConsumerPolicy
+
+	mutex  sync.RWMutex
+	topics map[string]map[*Subscription]struct{}
+	// topic => 取消在 broker 上的订阅
+	brokerSubs map[string]func()
+	closed     bool
+}
+
+type HubOption func(h *Hub)
+
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		broker:     NewMemoryBroker(),
+		bufferSize: 64,
+		topics:     map[string]map[*Subscription]struct{}{},
+		brokerSubs: map[string]func(){},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// HubWithBroker 设置 Broker，默认是 MemoryBroker
+func HubWithBroker(broker Broker) HubOption {
+	return func(h *Hub) {
+		h.broker = broker
+	}
+}
+
+// HubWithBufferSize 每个订阅者的缓冲区大小，默认是 64
+func HubWithBufferSize(size int) HubOption {
+	return func(h *Hub) {
+		h.bufferSize = size
+	}
+}
+
+// HubWithSlowConsumerPolicy 设置缓冲区满了之后的策略，默认是 DropNewest
+func HubWithSlowConsumerPolicy(policy SlowConsumerPolicy) HubOption {
+	return func(h *Hub) {
+		h.policy = policy
+	}
+}
+
+// Publish 发布消息，可以在进程里面的任何地方调用
+// 不会因为某个订阅者消费得慢而阻塞
+func (h *Hub) Publish(ctx context.Context, topic string, data []byte) error {
+	h.mutex.RLock()
+	closed := h.closed
+	h.mutex.RUnlock()
+	if closed {
+		return ErrHubClosed
+	}
+	return h.broker.Publish(ctx, HubMessage{Topic: topic, Data: data})
+}
+
+// Subscribe 创建一个订阅者，可以同时订阅多个 topic
+func (h *Hub) Subscribe(topics ...string) (*Subscription, error) {
+	s := &Subscription{
+		hub:    h,
+		topics: map[string]struct{}{},
+		ch:     make(chan HubMessage, h.bufferSize),
+	}
+	for _, topic := range topics {
+		if err := s.Join(topic); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Presence 当前节点上订阅了 topic 的订阅者数量
+func (h *Hub) Presence(topic string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.topics[topic])
+}
+
+// Shutdown 不再接收新的消息和订阅者，等所有订阅者把缓冲区里面的消息消费完，
+// 或者 ctx 过期之后，关闭所有的订阅者
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return nil
+	}
+	h.closed = true
+	for topic, unsubscribe := range h.brokerSubs {
+		unsubscribe()
+		delete(h.brokerSubs, topic)
+	}
+	subs := make(map[*Subscription]struct{}, 16)
+	for _, topicSubs := range h.topics {
+		for s := range topicSubs {
+			subs[s] = struct{}{}
+		}
+	}
+	h.mutex.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+	for !drained(subs) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	for s := range subs {
+		s.closeWith(ErrHubClosed)
+	}
+	return err
+}
+
+func drained(subs map[*Subscription]struct{}) bool {
+	for s := range subs {
+		if len(s.ch) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch 把 broker 投递过来的消息分发给本地的订阅者
+func (h *Hub) dispatch(msg HubMessage) {
+	var slow []*Subscription
+	h.mutex.RLock()
+	for s := range h.topics[msg.Topic] {
+		if !s.deliver(msg, h.policy) {
+			slow = append(slow, s)
+		}
+	}
+	h.mutex.RUnlock()
+	// 断开要修改 topics，所以不能在读锁里面做
+	for _, s := range slow {
+		s.closeWith(ErrSlowConsumer)
+	}
+}
+
+func (h *Hub) join(s *Subscription, topic string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return ErrHubClosed
+	}
+	subs, ok := h.topics[topic]
+	if !ok {
+		// 第一个订阅者，才需要在 broker 上订阅
+		unsubscribe, err := h.broker.Subscribe(topic, h.dispatch)
+		if err != nil {
+			return err
+		}
+		subs = map[*Subscription]struct{}{}
+		h.topics[topic] = subs
+		h.brokerSubs[topic] = unsubscribe
+	}
+	subs[s] = struct{}{}
+	return nil
+}
+
+func (h *Hub) leave(s *Subscription, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	subs, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) > 0 {
+		return
+	}
+	delete(h.topics, topic)
+	if unsubscribe, ok := h.brokerSubs[topic]; ok {
+		unsubscribe()
+		delete(h.brokerSubs, topic)
+	}
+}
+
+// Subscription 一个订阅者，一般对应一个 SSE 或者 websocket 连接
+type Subscription struct {
+	hub *Hub
+
+	mutex   sync.Mutex
+	topics  map[string]struct{}
+	ch      chan HubMessage
+	closed  bool
+	err     error
+	dropped uint64
+}
+
+// C 接收消息的 channel，订阅者被关闭之后 channel 也会被关闭
+func (s *Subscription) C() <-chan HubMessage {
+	return s.ch
+}
+
+// Join 订阅一个新的 topic
+func (s *Subscription) Join(topic string) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return s.err
+	}
+	if _, ok := s.topics[topic]; ok {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.topics[topic] = struct{}{}
+	s.mutex.Unlock()
+	if err := s.hub.join(s, topic); err != nil {
+		s.mutex.Lock()
+		delete(s.topics, topic)
+		s.mutex.Unlock()
+		return err
+	}
+	// 加入的过程中被关闭了
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		s.hub.leave(s, topic)
+	}
+	return nil
+}
+
+// Leave 取消订阅 topic
+func (s *Subscription) Leave(topic string) {
+	s.mutex.Lock()
+	_, ok := s.topics[topic]
+	delete(s.topics, topic)
+	s.mutex.Unlock()
+	if ok {
+		s.hub.leave(s, topic)
+	}
+}
+
+// Close 取消所有的订阅，重复调用是安全的
+func (s *Subscription) Close() {
+	s.closeWith(nil)
+}
+
+// Err 订阅者被 Hub 断开的原因，比如说 ErrSlowConsumer
+func (s *Subscription) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Dropped 因为缓冲区满了而被丢弃的消息数量
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// deliver 返回 false 代表需要断开这个订阅者
+func (s *Subscription) deliver(msg HubMessage, policy SlowConsumerPolicy) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+	}
+	switch policy {
+	case Disconnect:
+		return false
+	case DropOldest:
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- msg:
+		default:
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	return true
+}
+
+func (s *Subscription) closeWith(err error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	s.err = err
+	topics := s.topics
+	s.topics = map[string]struct{}{}
+	close(s.ch)
+	s.mutex.Unlock()
+	for topic := range topics {
+		s.hub.leave(s, topic)
+	}
+}
+
+// ForwardSSE 把收到的消息推送给 SSE 客户端，topic 作为事件类型
+// 客户端断开的时候返回 nil，被 Hub 断开的时候返回原因
+func (s *Subscription) ForwardSSE(stream *SSEStream) error {
+	for {
+		select {
+		case <-stream.Done():
+			return nil
+		case msg, ok := <-s.ch:
+			if !ok {
+				return s.Err()
+			}
+			if err := stream.Send(SSEEvent{Event: msg.Topic, Data: string(msg.Data)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ForwardWebSocket 把收到的消息推送给 websocket 客户端
+// 合法的 UTF-8 作为文本消息发送，其它的作为二进制消息发送
+// 需要在另外一个 goroutine 里面调用 ReadMessage，这样才能知道客户端断开了
+func (s *Subscription) ForwardWebSocket(conn *WebSocketConn) error {
+	for {
+		select {
+		case <-conn.Done():
+			return nil
+		case msg, ok := <-s.ch:
+			if !ok {
+				return s.Err()
+			}
+			typ := WSBinaryMessage
+			if utf8.Valid(msg.Data) {
+				typ = WSTextMessage
+			}
+			if err := conn.WriteMessage(typ, msg.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// MemoryBroker 单机的 Broker，直接在 Publish 里面调用 handler
+type MemoryBroker struct {
+	mutex    sync.RWMutex
+	id       uint64
+	handlers map[string]map[uint64]func(msg HubMessage)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: map[string]map[uint64]func(msg HubMessage){},
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, msg HubMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mutex.RLock()
+	handlers := make([]func(msg HubMessage), 0, len(b.handlers[msg.Topic]))
+	for _, handler := range b.handlers[msg.Topic] {
+		handlers = append(handlers, handler)
+	}
+	b.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(topic string, handler func(msg HubMessage)) (func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.id++
+	id := b.id
+	if b.handlers[topic] == nil {
+		b.handlers[topic] = map[uint64]func(msg HubMessage){}
+	}
+	b.handlers[topic][id] = handler
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.handlers[topic], id)
+		if len(b.handlers[topic]) == 0 {
+			delete(b.handlers, topic)
+		}
+	}, nil
+}
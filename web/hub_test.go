@@ -0,0 +1,199 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub()
+	s1, err := hub.Subscribe("order:1", "tenant:acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := hub.Subscribe("order:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, hub.Presence("order:1"))
+	assert.Equal(t, 1, hub.Presence("tenant:acme"))
+
+	ctx := context.Background()
+	assert.NoError(t, hub.Publish(ctx, "order:1", []byte("paid")))
+	assert.NoError(t, hub.Publish(ctx, "tenant:acme", []byte("hello")))
+	// 没有订阅者的 topic
+	assert.NoError(t, hub.Publish(ctx, "order:2", []byte("paid")))
+
+	assert.Equal(t, HubMessage{Topic: "order:1", Data: []byte("paid")}, <-s1.C())
+	assert.Equal(t, HubMessage{Topic: "tenant:acme", Data: []byte("hello")}, <-s1.C())
+	assert.Equal(t, HubMessage{Topic: "order:1", Data: []byte("paid")}, <-s2.C())
+	assert.Equal(t, 0, len(s2.C()))
+
+	s1.Leave("tenant:acme")
+	assert.Equal(t, 0, hub.Presence("tenant:acme"))
+	assert.NoError(t, s1.Join("order:2"))
+	assert.Equal(t, 1, hub.Presence("order:2"))
+
+	s1.Close()
+	s1.Close()
+	_, ok := <-s1.C()
+	assert.False(t, ok)
+	assert.Nil(t, s1.Err())
+	assert.Equal(t, 1, hub.Presence("order:1"))
+	assert.Equal(t, 0, hub.Presence("order:2"))
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy SlowConsumerPolicy
+
+		wantMsgs    []string
+		wantDropped uint64
+		wantErr     error
+	}{
+		{
+			name:        "drop newest",
+			policy:      DropNewest,
+			wantMsgs:    []string{"1", "2"},
+			wantDropped: 2,
+		},
+		{
+			name:        "drop oldest",
+			policy:      DropOldest,
+			wantMsgs:    []string{"3", "4"},
+			wantDropped: 2,
+		},
+		{
+			name:     "disconnect",
+			policy:   Disconnect,
+			wantMsgs: []string{"1", "2"},
+			wantErr:  ErrSlowConsumer,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := NewHub(HubWithBufferSize(2), HubWithSlowConsumerPolicy(tc.policy))
+			s, err := hub.Subscribe("topic")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range []string{"1", "2", "3", "4"} {
+				assert.NoError(t, hub.Publish(context.Background(), "topic", []byte(msg)))
+			}
+			if tc.wantErr != nil {
+				// 断开之后就不再是订阅者了
+				assert.Equal(t, 0, hub.Presence("topic"))
+				s.Close()
+			}
+			var msgs []string
+			for len(msgs) < len(tc.wantMsgs) {
+				msgs = append(msgs, string((<-s.C()).Data))
+			}
+			assert.Equal(t, tc.wantMsgs, msgs)
+			assert.Equal(t, tc.wantDropped, s.Dropped())
+			assert.Equal(t, tc.wantErr, s.Err())
+		})
+	}
+}
+
+func TestHub_Shutdown(t *testing.T) {
+	hub := NewHub()
+	s, err := hub.Subscribe("topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"1", "2", "3"} {
+		assert.NoError(t, hub.Publish(context.Background(), "topic", []byte(msg)))
+	}
+
+	var msgs []string
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for msg := range s.C() {
+			msgs = append(msgs, string(msg.Data))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, hub.Shutdown(ctx))
+	wg.Wait()
+	// 关闭之前，缓冲区里面的消息都被消费掉了
+	assert.Equal(t, []string{"1", "2", "3"}, msgs)
+	assert.Equal(t, ErrHubClosed, s.Err())
+	assert.Equal(t, ErrHubClosed, hub.Publish(context.Background(), "topic", []byte("4")))
+	_, err = hub.Subscribe("topic")
+	assert.Equal(t, ErrHubClosed, err)
+
+	// 没有人消费，超时之后直接关闭
+	hub = NewHub()
+	s, err = hub.Subscribe("topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, hub.Publish(context.Background(), "topic", []byte("1")))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, hub.Shutdown(ctx))
+	assert.Equal(t, ErrHubClosed, s.Err())
+}
+
+func TestSubscription_ForwardSSE(t *testing.T) {
+	hub := NewHub()
+	server := NewHTTPServer()
+	subscribed := make(chan struct{})
+	server.Get("/orders/:id/events", func(ctx *Context) {
+		s, err := hub.Subscribe("order:" + ctx.PathParams["id"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stream, err := ctx.SSE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		close(subscribed)
+		ctx.HandlerErr = s.ForwardSSE(stream)
+	})
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/orders/1/events", nil).WithContext(reqCtx)
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.ServeHTTP(recorder, req)
+	}()
+	<-subscribed
+	assert.NoError(t, hub.Publish(context.Background(), "order:1", []byte(`{"status":"paid"}`)))
+	assert.NoError(t, hub.Publish(context.Background(), "order:2", []byte(`{"status":"paid"}`)))
+	// 等消息发送出去之后，客户端断开
+	assert.Eventually(t, func() bool {
+		return hub.Presence("order:1") == 1 && len(hubSubs(hub, "order:1")[0].C()) == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, 0, hub.Presence("order:1"))
+	assert.Equal(t, "event: order:1\ndata: {\"status\":\"paid\"}\n\n", recorder.Body.String())
+}
+
+func hubSubs(hub *Hub, topic string) []*Subscription {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	res := make([]*Subscription, 0, len(hub.topics[topic]))
+	for s := range hub.topics[topic] {
+		res = append(res, s)
+	}
+	return res
+}
@@ -0,0 +1,332 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+const redacted = "[REDACTED]"
+
+// defaultRedactHeaders 开启了 CaptureBody 之后默认脱敏的头部
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// SampleRule 采样规则，按照添加的顺序匹配，第一个匹配上的生效
+// 没有任何规则匹配的时候，所有请求都会记录请求体和响应体
+type SampleRule struct {
+	// Route 命中的路由，比如说 /user/:id，为空代表所有的路由
+	Route string
+	// MinStatus 和 MaxStatus 是响应码的范围，闭区间，0 代表不限制
+	MinStatus int
+	MaxStatus int
+	// Rate 采样率，0 到 1 之间，比如说 0.01 就是 1%
+	Rate float64
+}
+
+func (r SampleRule) match(route string, status int) bool {
+	return (r.Route == "" || r.Route == route) &&
+		(r.MinStatus == 0 || status >= r.MinStatus) &&
+		(r.MaxStatus == 0 || status <= r.MaxStatus)
+}
+
+type captureConfig struct {
+	// enabled 只有 CaptureBody 会打开，别的选项只是配置脱敏和采样
+	enabled       bool
+	maxBytes      int
+	redactHeaders map[string]bool
+	jsonPaths     [][]string
+	patterns      []*regexp.Regexp
+	rules         []SampleRule
+	// 测试的时候替换掉
+	rand func() float64
+}
+
+// CaptureBody 在日志里面记录最多 maxBytes 字节的请求体和响应体，以及请求头和响应头
+// 请求体是预先读出来再放回去的，handler 依旧能读到完整的请求体。
+// 流式响应已经发送出去了，所以不会记录响应体
+func (m *MiddlewareBuilder) CaptureBody(maxBytes int) *MiddlewareBuilder {
+	c := m.captureConfig()
+	c.enabled = true
+	c.maxBytes = maxBytes
+	return m
+}
+
+// RedactHeaders 需要脱敏的头部，默认已经包括 Authorization, Cookie 之类的
+func (m *MiddlewareBuilder) RedactHeaders(names ...string) *MiddlewareBuilder {
+	c := m.captureConfig()
+	for _, name := range names {
+		c.redactHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+	return m
+}
+
+// RedactJSONPaths 需要脱敏的 JSON 字段，用 . 分隔，* 匹配任意的 key 或者数组元素，
+// 例如 password, user.token, cards.*.number
+// 只有一段的路径对 x-www-form-urlencoded 的请求体也生效
+func (m *MiddlewareBuilder) RedactJSONPaths(paths ...string) *MiddlewareBuilder {
+	c := m.captureConfig()
+	for _, path := range paths {
+		c.jsonPaths = append(c.jsonPaths, strings.Split(path, "."))
+	}
+	return m
+}
+
+// RedactPatterns 请求体、响应体和 URI 里面匹配 patterns 的部分会被替换掉
+// 比如说手机号，身份证号这种没有固定字段的。没有调用 CaptureBody 的时候只对 URI 生效
+func (m *MiddlewareBuilder) RedactPatterns(patterns ...*regexp.Regexp) *MiddlewareBuilder {
+	c := m.captureConfig()
+	c.patterns = append(c.patterns, patterns...)
+	return m
+}
+
+// Sample 设置请求体和响应体的采样规则，访问日志本身不受影响。例如
+//
+//	Sample(SampleRule{MinStatus: 500, Rate: 1}, SampleRule{Rate: 0.01})
+//
+// 就是 5xx 全部记录，其余的记录 1%
+func (m *MiddlewareBuilder) Sample(rules ...SampleRule) *MiddlewareBuilder {
+	c := m.captureConfig()
+	c.rules = append(c.rules, rules...)
+	return m
+}
+
+// SkipRoutes 不记录这些路由的访问日志，比如说健康检查
+// 可以是命中的路由，也可以是请求的路径
+func (m *MiddlewareBuilder) SkipRoutes(routes ...string) *MiddlewareBuilder {
+	if m.skipRoutes == nil {
+		m.skipRoutes = make(map[string]bool, len(routes))
+	}
+	for _, route := range routes {
+		m.skipRoutes[route] = true
+	}
+	return m
+}
+
+func (m *MiddlewareBuilder) skip(ctx *web.Context) bool {
+	return m.skipRoutes[ctx.MatchedRoute] || m.skipRoutes[ctx.Req.URL.Path]
+}
+
+func (m *MiddlewareBuilder) captureConfig() *captureConfig {
+	if m.capture == nil {
+		m.capture = &captureConfig{
+			redactHeaders: make(map[string]bool, len(defaultRedactHeaders)),
+			rand:          rand.Float64,
+		}
+		for _, name := range defaultRedactHeaders {
+			m.capture.redactHeaders[name] = true
+		}
+	}
+	return m.capture
+}
+
+// capturing 是否要记录请求体和响应体，c 可以是 nil
+func (c *captureConfig) capturing() bool {
+	return c != nil && c.enabled
+}
+
+// peek 预先读出最多 maxBytes + 1 字节，多读的一个字节用来判断有没有被截断
+// 返回的 io.ReadCloser 还能读到完整的请求体
+func (c *captureConfig) peek(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(c.maxBytes)+1))
+	var rest io.Reader = body
+	if err != nil {
+		// 把错误留给 handler
+		rest = &errReader{err: err}
+	}
+	return prefix, &peekedBody{Reader: io.MultiReader(bytes.NewReader(prefix), rest), Closer: body}
+}
+
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func (c *captureConfig) sampled(route string, status int) bool {
+	for _, r := range c.rules {
+		if r.match(route, status) {
+			return r.Rate >= 1 || r.Rate > 0 && c.rand() < r.Rate
+		}
+	}
+	return true
+}
+
+func (c *captureConfig) fill(l *accessLog, ctx *web.Context, reqBody []byte) {
+	l.ReqHeaders = c.headers(ctx.Req.Header)
+	l.RespHeaders = c.headers(ctx.RespHeader())
+	l.ReqBody = c.body(reqBody, ctx.Req.Header.Get("Content-Type"))
+	if !ctx.RespCommitted() {
+		l.RespBody = c.body(ctx.RespData, ctx.RespHeader().Get("Content-Type"))
+	}
+}
+
+func (c *captureConfig) headers(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(header))
+	for key, vals := range header {
+		if c.redactHeaders[http.CanonicalHeaderKey(key)] {
+			res[key] = redacted
+			continue
+		}
+		res[key] = strings.Join(vals, ", ")
+	}
+	return res
+}
+
+func (c *captureConfig) body(data []byte, contentType string) string {
+	if len(data) == 0 {
+		return ""
+	}
+	truncated := len(data) > c.maxBytes
+	if truncated {
+		data = trimPartialRune(data[:c.maxBytes])
+	}
+	if !utf8.Valid(data) {
+		return fmt.Sprintf("[binary %d bytes]", len(data))
+	}
+	res := string(data)
+	if len(c.jsonPaths) > 0 {
+		var ok bool
+		if res, ok = c.redactBody(data, contentType, truncated); !ok {
+			return res
+		}
+	}
+	res = c.redactPatterns(res)
+	if truncated {
+		res += "...(truncated)"
+	}
+	return res
+}
+
+// redactBody 按照字段脱敏，返回 false 的时候 res 是代替请求体的说明
+// 不管 Content-Type 是什么，都先试试按照 JSON 解析，因为客户端可能没有设置或者设置错了
+func (c *captureConfig) redactBody(data []byte, contentType string, truncated bool) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" {
+		mediaType = "body"
+	}
+	isForm := mediaType == "application/x-www-form-urlencoded"
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || looksLikeJSON(data)
+	if (isJSON || isForm) && truncated {
+		// 截断之后没有办法按照字段脱敏，为了安全直接丢弃
+		return fmt.Sprintf("[truncated %s omitted]", mediaType), false
+	}
+	if res, ok := c.redactJSON(data); ok {
+		return res, true
+	}
+	if isForm {
+		if res, ok := c.redactForm(string(data)); ok {
+			return res, true
+		}
+	}
+	if isJSON || isForm {
+		return fmt.Sprintf("[malformed %s omitted]", mediaType), false
+	}
+	return string(data), true
+}
+
+// looksLikeJSON JSON 对象或者数组
+func looksLikeJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
+
+func (c *captureConfig) redactPatterns(val string) string {
+	for _, p := range c.patterns {
+		val = p.ReplaceAllString(val, redacted)
+	}
+	return val
+}
+
+func (c *captureConfig) redactJSON(data []byte) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// 不然大的整数会丢失精度
+	dec.UseNumber()
+	var val any
+	if err := dec.Decode(&val); err != nil {
+		return "", false
+	}
+	// 后面还有别的内容的话，就不是 JSON
+	if _, err := dec.Token(); err != io.EOF {
+		return "", false
+	}
+	for _, path := range c.jsonPaths {
+		val = redactPath(val, path)
+	}
+	res, err := json.Marshal(val)
+	if err != nil {
+		return "", false
+	}
+	return string(res), true
+}
+
+func redactPath(val any, path []string) any {
+	if len(path) == 0 {
+		return redacted
+	}
+	switch v := val.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if path[0] == "*" || path[0] == key {
+				v[key] = redactPath(child, path[1:])
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				v[i] = redactPath(child, path[1:])
+			}
+		}
+	}
+	return val
+}
+
+func (c *captureConfig) redactForm(body string) (string, bool) {
+	vals, err := url.ParseQuery(body)
+	if err != nil {
+		return "", false
+	}
+	for _, path := range c.jsonPaths {
+		if len(path) != 1 {
+			continue
+		}
+		for key := range vals {
+			if path[0] == "*" || path[0] == key {
+				vals[key] = []string{redacted}
+			}
+		}
+	}
+	return vals.Encode(), true
+}
+
+// trimPartialRune 截断的时候可能把一个字符切成两半
+func trimPartialRune(data []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+		r, size := utf8.DecodeLastRune(data)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+	return data
+}
@@ -0,0 +1,207 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareBuilder_CaptureBody(t *testing.T) {
+	var logs []accessLog
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(func(log string) {
+		var l accessLog
+		if err := json.Unmarshal([]byte(log), &l); err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, l)
+	}).CaptureBody(64).
+		RedactHeaders("X-Partner-Secret").
+		RedactJSONPaths("password", "cards.*.number").
+		RedactPatterns(regexp.MustCompile(`1[3-9]\d{9}`), regexp.MustCompile(`token=[^&]+`))
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	var handlerBody string
+	server.Post("/user", func(ctx *web.Context) {
+		data, _ := io.ReadAll(ctx.Req.Body)
+		handlerBody = string(data)
+		ctx.RespHeader().Set("Set-Cookie", "sid=abc")
+		_ = ctx.RespJSONOK(map[string]string{"phone": "13812345678", "name": "Tom"})
+	})
+	server.Post("/login", func(ctx *web.Context) {
+		// 不读请求体也能记录下来
+		ctx.RespData = []byte("ok")
+	})
+
+	testCases := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+
+		wantReqBody  string
+		wantRespBody string
+	}{
+		{
+			name:         "json",
+			path:         "/user?token=abc&page=1",
+			contentType:  "application/json",
+			body:         `{"name":"Tom","password":"123456","cards":[{"number":"6222"}]}`,
+			wantReqBody:  `{"cards":[{"number":"[REDACTED]"}],"name":"Tom","password":"[REDACTED]"}`,
+			wantRespBody: `{"name":"Tom","phone":"[REDACTED]"}`,
+		},
+		{
+			name:         "form",
+			path:         "/login",
+			contentType:  "application/x-www-form-urlencoded",
+			body:         `name=Tom&password=123456`,
+			wantReqBody:  `name=Tom&password=%5BREDACTED%5D`,
+			wantRespBody: "ok",
+		},
+		{
+			// 截断的 JSON 没有办法脱敏
+			name:         "truncated json",
+			path:         "/user",
+			contentType:  "application/json",
+			body:         `{"name":"` + strings.Repeat("a", 64) + `","password":"123456"}`,
+			wantReqBody:  "[truncated application/json omitted]",
+			wantRespBody: `{"name":"Tom","phone":"[REDACTED]"}`,
+		},
+		{
+			// Content-Type 不对也要按照 JSON 脱敏
+			name:         "json without content type",
+			path:         "/login",
+			contentType:  "text/plain",
+			body:         `{"password":"123456"}`,
+			wantReqBody:  `{"password":"[REDACTED]"}`,
+			wantRespBody: "ok",
+		},
+		{
+			name:         "truncated json without content type",
+			path:         "/login",
+			contentType:  "",
+			body:         `{"name":"` + strings.Repeat("a", 64) + `","password":"123456"}`,
+			wantReqBody:  "[truncated body omitted]",
+			wantRespBody: "ok",
+		},
+		{
+			name:         "malformed json",
+			path:         "/login",
+			contentType:  "application/json",
+			body:         `{"password":"123456"} tail`,
+			wantReqBody:  "[malformed application/json omitted]",
+			wantRespBody: "ok",
+		},
+		{
+			name:         "truncated text",
+			path:         "/login",
+			contentType:  "text/plain",
+			body:         strings.Repeat("你", 30),
+			wantReqBody:  strings.Repeat("你", 21) + "...(truncated)",
+			wantRespBody: "ok",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs = nil
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			req.Header.Set("Authorization", "Bearer abc")
+			req.Header.Set("X-Partner-Secret", "abc")
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, 1, len(logs))
+			assert.Equal(t, tc.wantReqBody, logs[0].ReqBody)
+			assert.Equal(t, tc.wantRespBody, logs[0].RespBody)
+			assert.Equal(t, redacted, logs[0].ReqHeaders["Authorization"])
+			assert.Equal(t, redacted, logs[0].ReqHeaders["X-Partner-Secret"])
+			assert.Equal(t, tc.contentType, logs[0].ReqHeaders["Content-Type"])
+		})
+	}
+	// CLF 里面的 URI 也会脱敏
+	assert.Equal(t, "/user?[REDACTED]&page=1", builder.capture.redactPatterns("/user?token=abc&page=1"))
+	// handler 读到的还是完整的请求体
+	assert.Equal(t, `{"name":"`+strings.Repeat("a", 64)+`","password":"123456"}`, handlerBody)
+}
+
+func TestMiddlewareBuilder_Sample(t *testing.T) {
+	var logs []accessLog
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(func(log string) {
+		var l accessLog
+		if err := json.Unmarshal([]byte(log), &l); err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, l)
+	}).CaptureBody(1024).
+		Sample(SampleRule{MinStatus: 500, Rate: 1},
+			SampleRule{Route: "/user", Rate: 0.01},
+			SampleRule{Rate: 0}).
+		SkipRoutes("/health")
+	builder.capture.rand = func() float64 { return 0.5 }
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/health", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("user")
+	})
+	server.Get("/order", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		ctx.RespData = []byte("order")
+	})
+
+	for _, path := range []string{"/health", "/user", "/order"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	// 健康检查不记录，/user 没有被采样，5xx 全部采样
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, "/user", logs[0].Path)
+	assert.Equal(t, "", logs[0].RespBody)
+	assert.Equal(t, "/order", logs[1].Path)
+	assert.Equal(t, "order", logs[1].RespBody)
+
+	builder.capture.rand = func() float64 { return 0.001 }
+	logs = nil
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, "user", logs[0].RespBody)
+}
+
+func TestMiddlewareBuilder_RedactWithoutCapture(t *testing.T) {
+	var logs []string
+	builder := &MiddlewareBuilder{}
+	// 只配置脱敏，不记录请求头和请求体
+	builder.LogFunc(func(log string) {
+		logs = append(logs, log)
+	}).RedactPatterns(regexp.MustCompile(`token=[^&]+`)).
+		RedactHeaders("X-Partner-Secret")
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	var handlerBody string
+	server.Post("/user", func(ctx *web.Context) {
+		data, _ := io.ReadAll(ctx.Req.Body)
+		handlerBody = string(data)
+		ctx.RespData = []byte("ok")
+	})
+	req := httptest.NewRequest(http.MethodPost, "/user?token=abc&page=1", strings.NewReader(`{"name":"Tom"}`))
+	req.Header.Set("Authorization", "Bearer abc")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, `{"name":"Tom"}`, handlerBody)
+	assert.Equal(t, 1, len(logs))
+	assert.NotContains(t, logs[0], "req_headers")
+	assert.NotContains(t, logs[0], "req_body")
+	assert.NotContains(t, logs[0], "resp_body")
+
+	logs = nil
+	builder.Format(FormatCommon)
+	server = web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user?token=abc&page=1", nil))
+	assert.Contains(t, logs[0], `"GET /user?[REDACTED]&page=1 HTTP/1.1"`)
+}
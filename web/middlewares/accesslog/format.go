@@ -0,0 +1,144 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format 日志的格式
+type Format uint8
+
+const (
+	// FormatJSON 一行一个 JSON 对象，也是默认的格式
+	FormatJSON Format = iota
+	// FormatCommon Apache Common Log Format
+	//  127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326
+	FormatCommon
+	// FormatCombined 在 FormatCommon 的基础上加上 Referer 和 User-Agent
+	FormatCombined
+	// FormatLogfmt key=value 的形式，空的字段会被省略
+	FormatLogfmt
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+func (f Format) format(l *accessLog) string {
+	switch f {
+	case FormatCommon:
+		return common(l)
+	case FormatCombined:
+		return common(l) + " " + clfQuote(l.Referer) + " " + clfQuote(l.UserAgent)
+	case FormatLogfmt:
+		return logfmt(l)
+	default:
+		data, _ := json.Marshal(l)
+		return string(data)
+	}
+}
+
+func common(l *accessLog) string {
+	size := "-"
+	if l.RespBytes > 0 {
+		size = strconv.Itoa(l.RespBytes)
+	}
+	return strings.Join([]string{
+		clfValue(l.ClientIP),
+		// identd 早就没有人用了
+		"-",
+		clfValue(l.User),
+		"[" + l.Time.Format(clfTimeLayout) + "]",
+		clfQuote(l.HTTPMethod + " " + l.URI + " " + l.Proto),
+		strconv.Itoa(l.Status),
+		size,
+	}, " ")
+}
+
+func clfValue(val string) string {
+	if val == "" {
+		return "-"
+	}
+	return clfEscape(val)
+}
+
+func clfQuote(val string) string {
+	if val == "" {
+		return `"-"`
+	}
+	return `"` + clfEscape(val) + `"`
+}
+
+// clfEscape 和 Apache 一样，转义引号、反斜杠和不可见字符，防止伪造日志行
+func clfEscape(val string) string {
+	var sb strings.Builder
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			sb.WriteString(`\x`)
+			sb.WriteString(strconv.FormatUint(uint64(c)>>4, 16))
+			sb.WriteString(strconv.FormatUint(uint64(c)&0xf, 16))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func logfmt(l *accessLog) string {
+	var sb strings.Builder
+	write := func(key string, val string) {
+		if val == "" {
+			return
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		if strings.ContainsAny(val, " =\"\\\t\r\n") {
+			val = strconv.Quote(val)
+		}
+		sb.WriteString(val)
+	}
+	write("time", l.Time.Format(time.RFC3339Nano))
+	write("host", l.Host)
+	write("client_ip", l.ClientIP)
+	write("scheme", l.Scheme)
+	write("route", l.Route)
+	write("http_method", l.HTTPMethod)
+	write("path", l.Path)
+	write("proto", l.Proto)
+	write("user", l.User)
+	write("status", strconv.Itoa(l.Status))
+	write("latency_ms", strconv.FormatFloat(l.LatencyMS, 'f', -1, 64))
+	write("req_bytes", strconv.FormatInt(l.ReqBytes, 10))
+	write("resp_bytes", strconv.Itoa(l.RespBytes))
+	write("user_agent", l.UserAgent)
+	write("referer", l.Referer)
+	write("request_id", l.RequestID)
+	write("trace_id", l.TraceID)
+	for _, key := range sortedKeys(l.ReqHeaders) {
+		write("req_header."+key, l.ReqHeaders[key])
+	}
+	for _, key := range sortedKeys(l.RespHeaders) {
+		write("resp_header."+key, l.RespHeaders[key])
+	}
+	write("req_body", l.ReqBody)
+	write("resp_body", l.RespBody)
+	return sb.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
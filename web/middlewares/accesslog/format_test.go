@@ -0,0 +1,62 @@
+package accesslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	l := &accessLog{
+		Time:       time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Host:       "example.com",
+		ClientIP:   "127.0.0.1",
+		Scheme:     "http",
+		Route:      "/user/:id",
+		HTTPMethod: "GET",
+		Path:       "/user/12",
+		URI:        "/user/12?a=b",
+		Proto:      "HTTP/1.1",
+		User:       "frank",
+		Status:     200,
+		LatencyMS:  1.5,
+		RespBytes:  2326,
+		UserAgent:  `Mozilla/5.0 "evil"`,
+		RequestID:  "req-1",
+	}
+	testCases := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{
+			name:   "json",
+			format: FormatJSON,
+			want:   `{"time":"2000-10-10T13:55:36-07:00","host":"example.com","client_ip":"127.0.0.1","scheme":"http","route":"/user/:id","http_method":"GET","path":"/user/12","proto":"HTTP/1.1","user":"frank","status":200,"latency_ms":1.5,"req_bytes":0,"resp_bytes":2326,"user_agent":"Mozilla/5.0 \"evil\"","request_id":"req-1"}`,
+		},
+		{
+			name:   "common",
+			format: FormatCommon,
+			want:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /user/12?a=b HTTP/1.1" 200 2326`,
+		},
+		{
+			name:   "combined",
+			format: FormatCombined,
+			want:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /user/12?a=b HTTP/1.1" 200 2326 "-" "Mozilla/5.0 \"evil\""`,
+		},
+		{
+			name:   "logfmt",
+			format: FormatLogfmt,
+			want:   `time=2000-10-10T13:55:36-07:00 host=example.com client_ip=127.0.0.1 scheme=http route=/user/:id http_method=GET path=/user/12 proto=HTTP/1.1 user=frank status=200 latency_ms=1.5 req_bytes=0 resp_bytes=2326 user_agent="Mozilla/5.0 \"evil\"" request_id=req-1`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.format.format(l))
+		})
+	}
+
+	// 换行符不能伪造出新的日志行
+	assert.Equal(t, `"a\x0ab"`, clfQuote("a\nb"))
+}
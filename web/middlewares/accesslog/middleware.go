@@ -1,12 +1,22 @@
 package accesslog
 
 import (
-	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MiddlewareBuilder struct {
 	logFunc func(log string)
+	format  Format
+	// 脱敏和采样的配置，只有调用了 CaptureBody 才会记录请求体和响应体
+	capture    *captureConfig
+	skipRoutes map[string]bool
 }
 
 func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
@@ -14,19 +24,93 @@ func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
 	return m
 }
 
+// Output 每条日志作为一行写到 w 里面，比如说 RotatingWriter
+// 多个请求并发写入的时候，保证每一行都是完整的
+func (m *MiddlewareBuilder) Output(w io.Writer) *MiddlewareBuilder {
+	var mutex sync.Mutex
+	return m.LogFunc(func(log string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		_, _ = io.WriteString(w, log+"\n")
+	})
+}
+
+// Format 设置日志的格式，默认是 FormatJSON
+func (m *MiddlewareBuilder) Format(f Format) *MiddlewareBuilder {
+	m.format = f
+	return m
+}
+
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.logFunc == nil {
+		m.Output(os.Stdout)
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			if m.skip(ctx) {
+				// 这个时候还没有路由，只能按照路径判断。不需要记录的请求也不需要预先读请求体
+				next(ctx)
+				return
+			}
+			start := time.Now()
+			var body *countingReader
+			var reqBody []byte
+			if ctx.Req.Body != nil && ctx.Req.Body != http.NoBody {
+				body = &countingReader{ReadCloser: ctx.Req.Body}
+				ctx.Req.Body = body
+				if m.capture.capturing() {
+					reqBody, ctx.Req.Body = m.capture.peek(body)
+				}
+			}
 			// 要记录请求
 			defer func() {
+				if m.skip(ctx) {
+					return
+				}
 				l := accessLog{
-					Host:       ctx.Req.Host,
+					Time: start,
+					// 经过代理的时候，Req.Host 和 RemoteAddr 都是代理的
+					Host:       ctx.Host(),
+					ClientIP:   ctx.ClientIP(),
+					Scheme:     ctx.Scheme(),
 					Route:      ctx.MatchedRoute,
 					HTTPMethod: ctx.Req.Method,
 					Path:       ctx.Req.URL.Path,
+					URI:        ctx.Req.RequestURI,
+					Proto:      ctx.Req.Proto,
+					// 直接写 Resp 的时候，响应码也会被记录到 RespStatusCode 上
+					Status:    ctx.RespStatusCode,
+					LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+					ReqBytes:  ctx.Req.ContentLength,
+					RespBytes: ctx.RespSize(),
+					UserAgent: ctx.Req.UserAgent(),
+					Referer:   ctx.Req.Referer(),
+					RequestID: ctx.RequestID(),
+				}
+				if l.Status == 0 {
+					l.Status = http.StatusOK
 				}
-				data, _ := json.Marshal(l)
-				m.logFunc(string(data))
+				if l.URI == "" {
+					l.URI = ctx.Req.URL.RequestURI()
+				}
+				// chunked 的请求没有 Content-Length，只能看读了多少
+				if body != nil && (l.ReqBytes < 0 || body.n > l.ReqBytes) {
+					l.ReqBytes = body.n
+				}
+				if user, _, ok := ctx.Req.BasicAuth(); ok {
+					l.User = user
+				}
+				if sc := trace.SpanContextFromContext(ctx.Req.Context()); sc.HasTraceID() {
+					l.TraceID = sc.TraceID().String()
+				}
+				if m.capture != nil {
+					// 没有记录请求体的时候，URI 也要脱敏
+					l.URI = m.capture.redactPatterns(l.URI)
+				}
+				if m.capture.capturing() && m.capture.sampled(ctx.MatchedRoute, l.Status) {
+					m.capture.fill(&l, ctx, reqBody)
+				}
+				m.logFunc(m.format.format(&l))
 			}()
 			next(ctx)
 		}
@@ -34,9 +118,42 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 }
 
 type accessLog struct {
-	Host string `json:"host,omitempty"`
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	Scheme   string    `json:"scheme,omitempty"`
 	// 命中的路由
 	Route      string `json:"route,omitempty"`
 	HTTPMethod string `json:"http_method,omitempty"`
 	Path       string `json:"path,omitempty"`
+	// URI 包括查询参数，CLF 需要
+	URI   string `json:"-"`
+	Proto string `json:"proto,omitempty"`
+	// User Basic 认证的用户名
+	User      string  `json:"user,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	ReqBytes  int64   `json:"req_bytes"`
+	RespBytes int     `json:"resp_bytes"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	Referer   string  `json:"referer,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+	TraceID   string  `json:"trace_id,omitempty"`
+	// 下面的只有开启了 CaptureBody 才有
+	ReqHeaders  map[string]string `json:"req_headers,omitempty"`
+	RespHeaders map[string]string `json:"resp_headers,omitempty"`
+	ReqBody     string            `json:"req_body,omitempty"`
+	RespBody    string            `json:"resp_body,omitempty"`
+}
+
+// countingReader 记录读取了多少请求体
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
 }
@@ -1,9 +1,14 @@
 package accesslog
 
 import (
+	"encoding/json"
 	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -22,3 +27,58 @@ func TestMiddlewareBuilder(t *testing.T) {
 	}
 	server.ServeHTTP(nil, req)
 }
+
+func TestMiddlewareBuilder_Fields(t *testing.T) {
+	var logs []string
+	mdl := (&MiddlewareBuilder{}).LogFunc(func(log string) {
+		logs = append(logs, log)
+	}).Build()
+	server := web.NewHTTPServer(web.ServerWithMiddleware(mdl))
+	server.Post("/user/:id", func(ctx *web.Context) {
+		_, _ = io.ReadAll(ctx.Req.Body)
+		// 直接写 Resp 也能记录到响应码
+		ctx.Resp.WriteHeader(http.StatusCreated)
+		_, _ = ctx.Resp.Write([]byte("created"))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/user/12?a=b", strings.NewReader(`{"name":"Tom"}`))
+	req.RemoteAddr = "1.2.3.4:1234"
+	req.Header.Set("User-Agent", "curl/7.0")
+	req.Header.Set("Referer", "https://example.com")
+	req.Header.Set("X-Request-Id", "req-1")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, 1, len(logs))
+	var l map[string]any
+	if err := json.Unmarshal([]byte(logs[0]), &l); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, l["time"])
+	assert.GreaterOrEqual(t, l["latency_ms"], float64(0))
+	delete(l, "time")
+	delete(l, "latency_ms")
+	assert.Equal(t, map[string]any{
+		"host":        "example.com",
+		"client_ip":   "1.2.3.4",
+		"scheme":      "http",
+		"route":       "/user/:id",
+		"http_method": "POST",
+		"path":        "/user/12",
+		"proto":       "HTTP/1.1",
+		"status":      float64(201),
+		"req_bytes":   float64(14),
+		"resp_bytes":  float64(7),
+		"user_agent":  "curl/7.0",
+		"referer":     "https://example.com",
+		"request_id":  "req-1",
+	}, l)
+
+	// 没有 body，也没有 Content-Length
+	req = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	req.Body = nil
+	req.ContentLength = -1
+	assert.NotPanics(t, func() {
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	assert.Equal(t, 2, len(logs))
+}
@@ -0,0 +1,318 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeLayout = "20060102-150405.000"
+
+// RotatingWriter 按照大小和时间切割的日志文件
+// 切割下来的文件命名为 access-20221101-100000.000.log 这种形式，同一毫秒切割多次的时候加上序号，
+// 比如说 access-20221101-100000.000-1.log。可以选择用 gzip 压缩，压缩是在后台进行的，不会阻塞写入
+type RotatingWriter struct {
+	filename   string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	compress   bool
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+
+	// 后台的压缩和清理
+	bgMutex sync.Mutex
+	wg      sync.WaitGroup
+}
+
+type RotateOption func(w *RotatingWriter)
+
+// RotateWithMaxSize 文件超过 size 字节就切割，默认是 100MB
+func RotateWithMaxSize(size int64) RotateOption {
+	return func(w *RotatingWriter) {
+		w.maxSize = size
+	}
+}
+
+// RotateWithInterval 每隔 interval 切割一次，比如说 24 * time.Hour 就是每天切割
+// 时间是按照 UTC 对齐的，默认不按照时间切割
+func RotateWithInterval(interval time.Duration) RotateOption {
+	return func(w *RotatingWriter) {
+		w.interval = interval
+	}
+}
+
+// RotateWithMaxBackups 最多保留多少个切割下来的文件，默认全部保留
+// 作为合规记录的时候，应该由归档系统来删除
+func RotateWithMaxBackups(n int) RotateOption {
+	return func(w *RotatingWriter) {
+		w.maxBackups = n
+	}
+}
+
+// RotateWithCompress 用 gzip 压缩切割下来的文件
+func RotateWithCompress() RotateOption {
+	return func(w *RotatingWriter) {
+		w.compress = true
+	}
+}
+
+func NewRotatingWriter(filename string, opts ...RotateOption) (*RotatingWriter, error) {
+	res := &RotatingWriter{
+		filename: filename,
+		maxSize:  100 << 20,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, err
+	}
+	if err := res.open(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if w.shouldRotate(len(p)) {
+		// 切割失败的时候 rotate 会保留原来的文件，继续写进去，不能丢日志
+		rotateErr = w.rotate()
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Rotate 手动切割，比如说收到 SIGHUP 的时候
+// 失败的话返回 error，之后依旧写原来的文件
+func (w *RotatingWriter) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.rotate()
+}
+
+// Close 关闭文件，并且等待后台的压缩完成
+func (w *RotatingWriter) Close() error {
+	w.mutex.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mutex.Unlock()
+	w.wg.Wait()
+	return err
+}
+
+func (w *RotatingWriter) shouldRotate(n int) bool {
+	if w.size > 0 && w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	if w.interval <= 0 {
+		return false
+	}
+	return !w.now().Before(w.openedAt.Truncate(w.interval).Add(w.interval))
+}
+
+// open 打开日志文件，已经存在的话就追加
+func (w *RotatingWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.now()
+	if w.size > 0 {
+		// 上次运行留下来的文件，按照它的修改时间判断要不要切割
+		w.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// rotate 先改名再打开新的文件，都成功了才关闭原来的文件
+// 改名之后打开失败的话，把名字改回去，w.file 依旧是原来的文件
+func (w *RotatingWriter) rotate() error {
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	backup := w.backupName()
+	renamed := true
+	if err := os.Rename(w.filename, backup); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// 文件被别人删掉了，直接创建新的
+		renamed = false
+	}
+	old := w.file
+	if err := w.open(); err != nil {
+		if renamed {
+			_ = os.Rename(backup, w.filename)
+		}
+		return err
+	}
+	if err := old.Close(); err != nil {
+		return err
+	}
+	if !renamed {
+		return nil
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.bgMutex.Lock()
+		defer w.bgMutex.Unlock()
+		if w.compress {
+			// 压缩失败的话保留原文件，不能丢日志
+			_ = compressFile(backup)
+		}
+		w.removeOldBackups()
+	}()
+	return nil
+}
+
+// backupName 切割下来的文件名，已经存在的话加上序号
+// 压缩是在后台进行的，所以 .gz 的也要检查
+func (w *RotatingWriter) backupName() string {
+	ext := filepath.Ext(w.filename)
+	base := strings.TrimSuffix(w.filename, ext) + "-" + w.now().Format(backupTimeLayout)
+	res := base + ext
+	for seq := 1; exists(res) || exists(res+".gz"); seq++ {
+		res = base + "-" + strconv.Itoa(seq) + ext
+	}
+	return res
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+func compressFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(name + ".gz")
+		}
+	}()
+	gw := gzip.NewWriter(dst)
+	if _, err = io.Copy(gw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// backups 切割下来的文件，按照时间和序号从新到旧排列
+func (w *RotatingWriter) backups() ([]string, error) {
+	ext := filepath.Ext(w.filename)
+	prefix := filepath.Base(strings.TrimSuffix(w.filename, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(w.filename))
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		name string
+		ts   time.Time
+		seq  int
+	}
+	var bs []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts, seq, ok := parseBackup(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)[len(prefix):])
+		if !ok {
+			continue
+		}
+		bs = append(bs, backup{name: name, ts: ts, seq: seq})
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if !bs[i].ts.Equal(bs[j].ts) {
+			return bs[i].ts.After(bs[j].ts)
+		}
+		return bs[i].seq > bs[j].seq
+	})
+	res := make([]string, 0, len(bs))
+	for _, b := range bs {
+		res = append(res, b.name)
+	}
+	return res, nil
+}
+
+// parseBackup 解析 20221101-100000.000 或者 20221101-100000.000-1 这种形式
+func parseBackup(val string) (time.Time, int, bool) {
+	if ts, err := time.Parse(backupTimeLayout, val); err == nil {
+		return ts, 0, true
+	}
+	idx := strings.LastIndexByte(val, '-')
+	if idx < 0 {
+		return time.Time{}, 0, false
+	}
+	seq, err := strconv.Atoi(val[idx+1:])
+	if err != nil || seq <= 0 {
+		return time.Time{}, 0, false
+	}
+	ts, err := time.Parse(backupTimeLayout, val[:idx])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return ts, seq, true
+}
+
+func (w *RotatingWriter) removeOldBackups() {
+	if w.maxBackups <= 0 {
+		return
+	}
+	names, err := w.backups()
+	if err != nil || len(names) <= w.maxBackups {
+		return
+	}
+	dir := filepath.Dir(w.filename)
+	for _, name := range names[w.maxBackups:] {
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+}
@@ -0,0 +1,153 @@
+package accesslog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	now := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	w, err := NewRotatingWriter(filename, RotateWithMaxSize(10),
+		RotateWithInterval(time.Hour), RotateWithCompress(), RotateWithMaxBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+
+	write := func(data string) {
+		_, err := w.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	write("12345\n")
+	// 超过大小
+	write("67890\n")
+	now = now.Add(time.Second)
+	write("abcde\n")
+	// 跨过了整点
+	now = now.Add(time.Hour)
+	write("fghij\n")
+	now = now.Add(time.Second)
+	write("klmno\n")
+	assert.NoError(t, w.Close())
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	// 只保留最新的两个
+	assert.Equal(t, []string{
+		"access-20221101-110001.000.log.gz",
+		"access-20221101-110002.000.log.gz",
+		"access.log",
+	}, names)
+	assert.Equal(t, "abcde\n", readGzip(t, filepath.Join(dir, names[0])))
+	assert.Equal(t, "fghij\n", readGzip(t, filepath.Join(dir, names[1])))
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "klmno\n", string(data))
+
+	_, err = w.Write([]byte("closed"))
+	assert.Equal(t, os.ErrClosed, err)
+}
+
+func TestRotatingWriter_sameTime(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	now := time.Date(2022, 11, 1, 10, 0, 0, 0, time.UTC)
+	w, err := NewRotatingWriter(filename, RotateWithMaxSize(1), RotateWithMaxBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return now }
+	// 同一毫秒里面切割了多次，不能覆盖掉前面的
+	for _, data := range []string{"a", "b", "c", "d"} {
+		_, err = w.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	// 只保留最新的两个
+	assert.Equal(t, []string{
+		"access-20221101-100000.000-1.log",
+		"access-20221101-100000.000-2.log",
+		"access.log",
+	}, names)
+	data, err := os.ReadFile(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "c", string(data))
+}
+
+func TestRotatingWriter_rotateFailed(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	filename := filepath.Join(dir, "access.log")
+	w, err := NewRotatingWriter(filename, RotateWithMaxSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte("a"))
+	assert.NoError(t, err)
+
+	// 目录没了，新的文件创建不出来
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write([]byte("b"))
+	assert.Equal(t, 1, n)
+	assert.Error(t, err)
+	assert.NotEqual(t, os.ErrClosed, err)
+
+	// 恢复之后就能正常切割了
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte("c"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "c", string(data))
+}
+
+func readGzip(t *testing.T, name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
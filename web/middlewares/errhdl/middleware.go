@@ -1,33 +1,86 @@
 package errhdl
 
-import "gitee.com/geektime-geekbang/geektime-go/web"
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+// skipKey 放在 Context 上的标记，代表 handler 不希望响应被改写
+const skipKey = "errhdl.skip"
+
+// Skip 在 handler 里面调用，保留 handler 自己写的错误响应
+func Skip(ctx *web.Context) {
+	ctx.Set(skipKey, true)
+}
+
+// Renderer 渲染错误响应
+// 原始的响应码和响应都在 ctx.RespStatusCode 和 ctx.RespData 上，
+// err 是 ctx.HandlerErr，路由没有命中的时候是 nil
+type Renderer func(ctx *web.Context, err error)
 
 type MiddlewareBuilder struct {
-	// 这种设计只能返回固定的值
-	// 不能做到动态渲染
-	resp map[int][]byte
+	// 响应码 => Renderer
+	renderers map[int]Renderer
+	// 按照注册的顺序匹配，精确的响应码优先
+	ranges []statusRange
+}
+
+type statusRange struct {
+	from, to int
+	renderer Renderer
 }
 
 func NewMiddlewareBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
-		resp: map[int][]byte{},
+		renderers: map[int]Renderer{},
 	}
 }
 
+// AddCode 响应码为 status 的时候，响应固定的 data
 func (m *MiddlewareBuilder) AddCode(status int, data []byte) *MiddlewareBuilder {
-	m.resp[status]=data
+	return m.Render(status, func(ctx *web.Context, err error) {
+		// 篡改结果
+		ctx.RespData = data
+	})
+}
+
+// Render 响应码为 status 的时候，用 r 渲染响应
+func (m *MiddlewareBuilder) Render(status int, r Renderer) *MiddlewareBuilder {
+	m.renderers[status] = r
+	return m
+}
+
+// RenderRange 响应码在 [from, to] 之间的时候，用 r 渲染响应，比如说 500 到 599
+func (m *MiddlewareBuilder) RenderRange(from int, to int, r Renderer) *MiddlewareBuilder {
+	m.ranges = append(m.ranges, statusRange{from: from, to: to, renderer: r})
 	return m
 }
 
-func (m MiddlewareBuilder) Build() web.Middleware{
+// Build 要放在 recover 之前，也就是更外层，这样 recover 转换之后的 500 也会被渲染
+// error 转换为响应码和响应是 HTTPServer 上的 ErrorHandler 负责的，这里只按照响应码替换响应，
+// 没有注册 Renderer 的响应码，保留 ErrorHandler 或者 handler 自己写的响应
+func (m MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			next(ctx)
-			resp, ok := m.resp[ctx.RespStatusCode]
-			if ok {
-				// 篡改结果
-				ctx.RespData = resp
+			if skip, _ := web.GetAs[bool](ctx, skipKey); skip || ctx.RespCommitted() {
+				// 流式响应已经发出去了，改不了
+				return
+			}
+			if r := m.renderer(ctx.RespStatusCode); r != nil {
+				r(ctx, ctx.HandlerErr)
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func (m MiddlewareBuilder) renderer(status int) Renderer {
+	if r, ok := m.renderers[status]; ok {
+		return r
+	}
+	for _, sr := range m.ranges {
+		if status >= sr.from && status <= sr.to {
+			return sr.renderer
+		}
+	}
+	return nil
+}
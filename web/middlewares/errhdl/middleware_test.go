@@ -2,8 +2,13 @@ package errhdl
 
 import (
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"gitee.com/geektime-geekbang/geektime-go/web/middlewares/recover"
+	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
@@ -25,3 +30,184 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
 	server.Start(":8081")
 }
+
+func TestMiddlewareBuilder_HandlerErr(t *testing.T) {
+	type createUserReq struct {
+		ID   int    `path:"id"`
+		Name string `json:"name" validate:"required"`
+	}
+	// error 由 ErrorHandler 转换为响应，没有注册 Renderer 的响应码保持原样
+	builder := NewMiddlewareBuilder()
+	server := web.NewHTTPServer(
+		web.ServerWithErrorHandler(web.ProblemErrorHandler),
+		web.ServerWithMiddleware(builder.Build()))
+	server.PostE("/user/:id", func(ctx *web.Context) error {
+		var req createUserReq
+		if err := ctx.BindAndValidate(&req); err != nil {
+			return err
+		}
+		ctx.RespData = []byte("ok")
+		return nil
+	})
+
+	testCases := []struct {
+		name     string
+		path     string
+		body     string
+		lang     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "ok",
+			path:     "/user/1",
+			body:     `{"name":"Tom"}`,
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "validation",
+			path:     "/user/1",
+			body:     `{}`,
+			lang:     "en-US,en;q=0.9",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"The request failed validation","instance":"/user/1","code":"validation_failed",` +
+				`"errors":[{"field":"name","tag":"required","message":"name is required"}]}`,
+		},
+		{
+			name:     "bind",
+			path:     "/user/abc",
+			body:     `{"name":"Tom"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"请求参数不合法","instance":"/user/abc","code":"bad_request",` +
+				`"errors":[{"field":"ID","source":"path","message":"web: 字段 ID 绑定失败, path:\"id\" 的值 \"abc\" 不合法: strconv.ParseInt: parsing \"abc\": invalid syntax"}]}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", tc.lang)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Render(t *testing.T) {
+	engine, err := web.NewGoTemplateEngine(fstest.MapFS{
+		"404.gohtml": {Data: []byte(`<h1>{{.Path}} 走失了</h1>`)},
+	}, "*.gohtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewMiddlewareBuilder().
+		Render(http.StatusNotFound, Negotiate(JSON(), HTML("404.gohtml"))).
+		Render(http.StatusMethodNotAllowed, Default()).
+		Render(http.StatusUnprocessableEntity, JSON()).
+		RenderRange(500, 599, func(ctx *web.Context, err error) {
+			ctx.RespData = []byte("服务器开小差了: " + err.Error())
+		})
+	rec := recover.MiddlewareBuilder{
+		StatusCode: http.StatusInternalServerError,
+		Data:       []byte("你 panic 了"),
+		StackDepth: -1,
+	}
+	server := web.NewHTTPServer(
+		web.ServerWithTemplateEngine(engine),
+		// errhdl 在外层，这样 recover 转换之后的 500 也会被渲染
+		web.ServerWithMiddleware(builder.Build(), rec.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		panic("数据库挂了")
+	})
+	server.Get("/order", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusNotFound
+		ctx.RespData = []byte(`{"msg":"订单不存在"}`)
+		Skip(ctx)
+	})
+	server.PostE("/order", func(ctx *web.Context) error {
+		var req struct {
+			Name string `json:"name" validate:"required"`
+		}
+		return ctx.BindAndValidate(&req)
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		accept string
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "not found browser",
+			path:     "/product",
+			accept:   "text/html,application/xhtml+xml,*/*;q=0.8",
+			wantCode: http.StatusNotFound,
+			wantBody: `<h1>/product 走失了</h1>`,
+		},
+		{
+			name:     "not found api",
+			path:     "/product",
+			accept:   "application/json",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"type":"about:blank","title":"Not Found","status":404,"detail":"资源不存在","instance":"/product","code":"not_found"}`,
+		},
+		{
+			name:     "method not allowed",
+			method:   http.MethodPost,
+			path:     "/user",
+			wantCode: http.StatusMethodNotAllowed,
+			wantBody: `{"type":"about:blank","title":"Method Not Allowed","status":405,"detail":"不支持该请求方法","instance":"/user","code":"method_not_allowed"}`,
+		},
+		{
+			name:     "method not allowed browser",
+			method:   http.MethodPost,
+			path:     "/user",
+			accept:   "text/html",
+			wantCode: http.StatusMethodNotAllowed,
+			wantBody: "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>405 Method Not Allowed</title></head>\n<body>\n\t<h1>405 Method Not Allowed</h1>\n\t<p>Method Not Allowed</p>\n</body>\n</html>\n",
+		},
+		{
+			// 和 web.ProblemErrorHandler 的格式一样
+			name:     "validation",
+			method:   http.MethodPost,
+			path:     "/order",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"请求参数校验失败","instance":"/order","code":"validation_failed",` +
+				`"errors":[{"field":"name","tag":"required","message":"name 不能为空"}]}`,
+		},
+		{
+			name:     "panic",
+			path:     "/user",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "服务器开小差了: recover: 数据库挂了",
+		},
+		{
+			name:     "skip",
+			path:     "/order",
+			accept:   "text/html",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"msg":"订单不存在"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.path, strings.NewReader("{}"))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", tc.accept)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
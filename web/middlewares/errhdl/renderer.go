@@ -0,0 +1,100 @@
+package errhdl
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+// ErrorPage 传给 HTML 模板的数据
+type ErrorPage struct {
+	Status int
+	// Title 响应码对应的描述，比如说 Not Found
+	Title string
+	// Message 4xx 的时候是 error 的信息，5xx 的时候不会暴露 error
+	Message string
+	Path    string
+	// Lang Accept-Language 里面优先级最高的语言，模板可以按照它选择文案
+	Lang string
+}
+
+func newErrorPage(ctx *web.Context, err error) ErrorPage {
+	status := ctx.RespStatusCode
+	page := ErrorPage{
+		Status:  status,
+		Title:   http.StatusText(status),
+		Message: http.StatusText(status),
+		Path:    ctx.Req.URL.Path,
+		Lang:    ctx.PreferredLanguage(),
+	}
+	if err != nil && status < 500 {
+		page.Message = err.Error()
+	}
+	return page
+}
+
+// Default API 客户端响应 application/problem+json，浏览器响应内置的错误页面
+func Default() Renderer {
+	return Negotiate(JSON(), HTML(""))
+}
+
+// Negotiate 根据 Accept 选择 Renderer
+// 浏览器的 Accept 里面 text/html 优先级最高，所以用 html，
+// 其余的，包括没有 Accept 和 */* 的 API 客户端，都用 json
+func Negotiate(json Renderer, html Renderer) Renderer {
+	return func(ctx *web.Context, err error) {
+		ctx.RespHeader().Add("Vary", "Accept")
+		if ctx.Negotiate("application/json", "application/problem+json", "text/html") == "text/html" {
+			html(ctx, err)
+			return
+		}
+		json(ctx, err)
+	}
+}
+
+// JSON 以 application/problem+json 响应，和 web.ProblemErrorHandler 的格式一样
+// err 就是造成这个响应码的错误的时候，使用 ctx.ErrorProblem，比如说校验失败会带上字段错误的列表；
+// 否则使用响应码默认的错误码，比如说 404 是 not_found，带上 code 和对应语言的 detail
+func JSON() Renderer {
+	return func(ctx *web.Context, err error) {
+		status := ctx.RespStatusCode
+		if err != nil && web.ErrorStatus(err) == status {
+			_ = ctx.WriteProblem(ctx.ErrorProblem(err))
+			return
+		}
+		p := ctx.StatusProblem(status)
+		if err != nil && status < 500 {
+			p.Detail = err.Error()
+		}
+		_ = ctx.WriteProblem(p)
+	}
+}
+
+// HTML 用服务器上的模板引擎渲染 tplName，模板拿到的数据是 ErrorPage
+// tplName 为空，或者渲染失败的时候，使用内置的错误页面
+func HTML(tplName string) Renderer {
+	return func(ctx *web.Context, err error) {
+		page := newErrorPage(ctx, err)
+		if tplName != "" && ctx.RenderTemplate(page.Status, tplName, page) == nil {
+			return
+		}
+		buf := &bytes.Buffer{}
+		if defaultPage.Execute(buf, page) != nil {
+			ctx.String(page.Status, page.Message)
+			return
+		}
+		ctx.HTML(page.Status, buf.String())
+	}
+}
+
+var defaultPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.Title}}</title></head>
+<body>
+	<h1>{{.Status}} {{.Title}}</h1>
+	<p>{{.Message}}</p>
+</body>
+</html>
+`))
@@ -1,9 +1,18 @@
 package opentelemetry
 
 import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -12,6 +21,8 @@ const instrumentationName = "gitee.com/geektime-geekbang/geektime-go/web/middlew
 
 type MiddlewareBuilder struct {
 	Tracer trace.Tracer
+	// Meter 用来记录 http.server.request.duration，默认使用全局的 MeterProvider
+	Meter metric.Meter
 }
 
 // func NewMiddlewareBuilder(tracer trace.Tracer) *MiddlewareBuilder {
@@ -24,42 +35,125 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 	if m.Tracer == nil {
 		m.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
 	}
+	if m.Meter == nil {
+		m.Meter = global.Meter(instrumentationName)
+	}
+	duration, err := m.Meter.SyncFloat64().Histogram("http.server.request.duration",
+		instrument.WithUnit("s"),
+		instrument.WithDescription("HTTP 请求的响应时间"))
+	if err != nil {
+		// 指标不应该影响业务
+		otel.Handle(err)
+		duration, _ = metric.NewNoopMeter().SyncFloat64().Histogram("http.server.request.duration")
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
-
+			startTime := time.Now()
 			reqCtx := ctx.Req.Context()
 			// 尝试和客户端的 trace 结合在一起
 			reqCtx = otel.GetTextMapPropagator().Extract(reqCtx, propagation.HeaderCarrier(ctx.Req.Header))
 
-			reqCtx, span := m.Tracer.Start(reqCtx, "unknown")
-			defer span.End()
-
-			// defer func() {
-			// 	// 这个是只有执行完 next 才可能有值
-			// 	span.SetName(ctx.MatchedRoute)
-			//
-			// 	// 把响应码加上去
-			// 	span.SetAttributes(attribute.Int("http.status", ctx.RespStatusCode))
-			// 	span.End()
-			// }()
-
-			span.SetAttributes(attribute.String("http.method", ctx.Req.Method))
-			span.SetAttributes(attribute.String("http.url", ctx.Req.URL.String()))
-			span.SetAttributes(attribute.String("http.scheme", ctx.Req.URL.Scheme))
-			span.SetAttributes(attribute.String("http.host", ctx.Req.Host))
-
-			// 你这里还可以继续加
+			// 这个时候还不知道命中的路由，先用 method 作为名字
+			reqCtx, span := m.Tracer.Start(reqCtx, ctx.Req.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(requestAttributes(ctx)...))
+			// 发送响应之后才结束，这样 span 包括了 web.flush 阶段，
+			// 它也是 web.flush 的父 span
+			ctx.AfterFlush(func() {
+				span.End()
+			})
 
+			// web.Context 的 Deadline, Done, Err 和 Value 都是委托给 ctx.Req.Context() 的
+			// 所以替换掉 Req 之后，用户直接把 ctx 传给下游，也能拿到 span
 			ctx.Req = ctx.Req.WithContext(reqCtx)
-			// ctx.Ctx = reqCtx
 
 			// 直接调用下一步
 			next(ctx)
-			// 这个是只有执行完 next 才可能有值
-			span.SetName(ctx.MatchedRoute)
 
-			// 把响应码加上去
-			span.SetAttributes(attribute.Int("http.status", ctx.RespStatusCode))
+			// 这些只有执行完 next 才有值
+			status := ctx.RespStatusCode
+			if status == 0 {
+				status = 200
+			}
+			attrs := []attribute.KeyValue{
+				attribute.String("http.request.method", ctx.Req.Method),
+				attribute.String("url.scheme", ctx.Scheme()),
+				attribute.Int("http.response.status_code", status),
+			}
+			if ctx.MatchedRoute != "" {
+				span.SetName(ctx.Req.Method + " " + ctx.MatchedRoute)
+				attrs = append(attrs, attribute.String("http.route", ctx.MatchedRoute))
+			}
+			span.SetAttributes(attrs...)
+			span.SetAttributes(attribute.Int("http.response.body.size", ctx.RespSize()))
+			// HandleFuncE 返回的 error，以事件的形式记录下来
+			if ctx.HandlerErr != nil {
+				span.RecordError(ctx.HandlerErr)
+			}
+			// 服务端的 span 只有 5xx 才算出错，4xx 是客户端的问题
+			if status >= 500 {
+				span.SetStatus(codes.Error, "")
+				attrs = append(attrs, attribute.String("error.type", strconv.Itoa(status)))
+			}
+			duration.Record(reqCtx, time.Since(startTime).Seconds(), attrs...)
+		}
+	}
+}
+
+// PhaseHook 给每一个 middleware 和路由、handler、flush 这些阶段创建一个子 span，
+// 用来看慢请求的时间到底花在了哪里。要和 Build 一起使用，并且 Build 返回的 middleware 要放在第一个：
+//
+//	builder := MiddlewareBuilder{}
+//	web.NewHTTPServer(web.ServerWithMiddleware(builder.Build(), ...), web.ServerWithPhaseHook(builder.PhaseHook()))
+//
+// 没有父 span 的阶段不会创建 span，所以在它前面的 middleware 是看不到的。
+// Build 创建的 span 在发送响应之后才结束，所以 web.flush 也是它的子 span
+func (m MiddlewareBuilder) PhaseHook() web.PhaseHook {
+	if m.Tracer == nil {
+		m.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+	return func(ctx *web.Context, name string) func() {
+		prevCtx := ctx.Req.Context()
+		if !trace.SpanContextFromContext(prevCtx).IsValid() {
+			return func() {}
+		}
+		spanCtx, span := m.Tracer.Start(prevCtx, name, trace.WithSpanKind(trace.SpanKindInternal))
+		ctx.Req = ctx.Req.WithContext(spanCtx)
+		return func() {
+			span.End()
+			// 只恢复 context，后面的 middleware 可能替换了 Req 的其它部分
+			ctx.Req = ctx.Req.WithContext(prevCtx)
+		}
+	}
+}
+
+// requestAttributes 在 span 开始的时候就能确定的属性，采样器可以用到
+func requestAttributes(ctx *web.Context) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", ctx.Req.Method),
+		attribute.String("url.path", ctx.Req.URL.Path),
+		// 经过代理的时候，Req 上面的都是代理的信息
+		attribute.String("url.scheme", ctx.Scheme()),
+		attribute.String("client.address", ctx.ClientIP()),
+	}
+	if ctx.Req.URL.RawQuery != "" {
+		attrs = append(attrs, attribute.String("url.query", ctx.Req.URL.RawQuery))
+	}
+	host := ctx.Host()
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, attribute.Int("server.port", p))
 		}
 	}
+	if host != "" {
+		attrs = append(attrs, attribute.String("server.address", host))
+	}
+	if ua := ctx.Req.UserAgent(); ua != "" {
+		attrs = append(attrs, attribute.String("user_agent.original", ua))
+	}
+	if strings.HasPrefix(ctx.Req.Proto, "HTTP/") {
+		attrs = append(attrs, attribute.String("network.protocol.version", strings.TrimPrefix(ctx.Req.Proto, "HTTP/")))
+	}
+	return attrs
 }
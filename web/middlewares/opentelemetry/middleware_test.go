@@ -1,96 +1,186 @@
-//go:build e2e
 package opentelemetry
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
 	"gitee.com/geektime-geekbang/geektime-go/web"
-	"go.opentelemetry.io/otel"
+	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/exporters/zipkin"
-	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
-	"log"
-	"os"
-	"testing"
-	"time"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func TestMiddlewareBuilder_Build(t *testing.T) {
-	tracer := otel.GetTracerProvider().Tracer(instrumentationName)
+func TestMiddlewareBuilder_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
 	builder := MiddlewareBuilder{
-		Tracer: tracer,
+		Tracer: tp.Tracer(instrumentationName),
+		Meter:  mp.Meter(instrumentationName),
 	}
 	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
-
-	server.Get("/user", func(ctx *web.Context) {
-		c, span := tracer.Start(ctx.Req.Context(), "first_layer")
-		defer span.End()
-
-		secondC, second := tracer.Start(c, "second_layer")
-		time.Sleep(time.Second)
-		_, third1 := tracer.Start(secondC, "third_layer_1")
-		time.Sleep(100 * time.Millisecond)
-		third1.End()
-		_, third2 := tracer.Start(secondC, "third_layer_2")
-		time.Sleep(300 * time.Millisecond)
-		third2.End()
-		second.End()
-
-		_, first := tracer.Start(ctx.Req.Context(), "first_layer_1")
-		defer first.End()
-		time.Sleep(100 * time.Millisecond)
-		ctx.RespJSON(202, User{
-			Name: "Tom",
-		})
+	server.Get("/user/:id", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+	server.GetE("/order/:id", func(ctx *web.Context) error {
+		return web.NewHTTPError(http.StatusConflict, "", "订单已经支付")
+	})
+	server.GetE("/pay", func(ctx *web.Context) error {
+		return errors.New("支付渠道超时")
 	})
 
-	initZipkin(t)
-
-	server.Start(":8081")
-}
+	testCases := []struct {
+		name string
+		path string
 
-type User struct {
-	Name string
-}
+		wantName   string
+		wantStatus codes.Code
+		wantAttrs  []attribute.KeyValue
+		wantEvents int
+	}{
+		{
+			name:     "ok",
+			path:     "/user/12?a=b",
+			wantName: "GET /user/:id",
+			wantAttrs: []attribute.KeyValue{
+				attribute.String("http.request.method", "GET"),
+				attribute.String("url.path", "/user/12"),
+				attribute.String("url.query", "a=b"),
+				attribute.String("url.scheme", "http"),
+				attribute.String("client.address", "192.0.2.1"),
+				attribute.String("server.address", "example.com"),
+				attribute.String("network.protocol.version", "1.1"),
+				attribute.String("http.route", "/user/:id"),
+				attribute.Int("http.response.status_code", 200),
+				attribute.Int("http.response.body.size", 5),
+			},
+		},
+		{
+			// 4xx 不是服务端的错误，但是 error 会被记录下来
+			name:       "client error",
+			path:       "/order/12",
+			wantName:   "GET /order/:id",
+			wantEvents: 1,
+			wantAttrs: []attribute.KeyValue{
+				attribute.String("http.route", "/order/:id"),
+				attribute.Int("http.response.status_code", 409),
+			},
+		},
+		{
+			name:       "server error",
+			path:       "/pay",
+			wantName:   "GET /pay",
+			wantStatus: codes.Error,
+			wantEvents: 1,
+			wantAttrs: []attribute.KeyValue{
+				attribute.Int("http.response.status_code", 500),
+			},
+		},
+		{
+			// 没有命中路由，不能用路径作为名字
+			name:     "not found",
+			path:     "/a/b/c",
+			wantName: "GET",
+			wantAttrs: []attribute.KeyValue{
+				attribute.Int("http.response.status_code", 404),
+			},
+		},
+	}
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+			spans := recorder.Ended()
+			assert.Equal(t, i+1, len(spans))
+			span := spans[i]
+			assert.Equal(t, tc.wantName, span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, tc.wantStatus, span.Status().Code)
+			assert.Equal(t, tc.wantEvents, len(span.Events()))
+			attrs := make(map[attribute.Key]attribute.Value, len(span.Attributes()))
+			for _, attr := range span.Attributes() {
+				attrs[attr.Key] = attr.Value
+			}
+			for _, want := range tc.wantAttrs {
+				assert.Equal(t, want.Value, attrs[want.Key], string(want.Key))
+			}
+		})
+	}
 
-func initZipkin(t *testing.T) {
-	exporter, err := zipkin.New(
-		"http://localhost:19411/api/v2/spans",
-		zipkin.WithLogger(log.New(os.Stderr, "opentelemetry-demo", log.Ldate|log.Ltime|log.Llongfile)),
-	)
+	rm, err := reader.Collect(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
+	metrics := rm.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "http.server.request.duration", metrics.Name)
+	assert.Equal(t, "s", string(metrics.Unit))
+	hist := metrics.Data.(metricdata.Histogram)
+	// 每个路由和响应码一个数据点
+	assert.Equal(t, 4, len(hist.DataPoints))
+	for _, dp := range hist.DataPoints {
+		assert.Equal(t, uint64(1), dp.Count)
+		status, _ := dp.Attributes.Value("http.response.status_code")
+		_, hasErr := dp.Attributes.Value("error.type")
+		assert.Equal(t, status.AsInt64() >= 500, hasErr)
+	}
+}
 
-	batcher := sdktrace.NewBatchSpanProcessor(exporter)
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSpanProcessor(batcher),
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String("opentelemetry-demo"),
-		)),
-	)
-	otel.SetTracerProvider(tp)
+func TestMiddlewareBuilder_Propagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	builder := MiddlewareBuilder{Tracer: tp.Tracer(instrumentationName)}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	var traceID trace.TraceID
+	server.Get("/user", func(ctx *web.Context) {
+		// 直接把 ctx 传下去也能拿到 span
+		traceID = trace.SpanContextFromContext(ctx).TraceID()
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	spans := recorder.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, spans[0].SpanContext().TraceID(), traceID)
 }
 
-func initJeager(t *testing.T) {
-	url := "http://localhost:14268/api/traces"
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		t.Fatal(err)
+func TestMiddlewareBuilder_PhaseHook(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	builder := MiddlewareBuilder{Tracer: tp.Tracer(instrumentationName)}
+	auth := func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+		}
 	}
-	tp := sdktrace.NewTracerProvider(
-		// Always be sure to batch in production.
-		sdktrace.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String("opentelemetry-demo"),
-			attribute.String("environment", "dev"),
-			attribute.Int64("ID", 1),
-		)),
-	)
+	server := web.NewHTTPServer(
+		web.ServerWithMiddleware(builder.Build(), web.Named("auth", auth)),
+		web.ServerWithPhaseHook(builder.PhaseHook()))
+	var handlerSpan trace.SpanContext
+	server.Get("/user", func(ctx *web.Context) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
 
-	otel.SetTracerProvider(tp)
+	spans := recorder.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan, len(spans))
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	assert.Equal(t, 5, len(spans))
+	root := byName["GET /user"]
+	assert.Equal(t, root.SpanContext(), byName["auth"].Parent())
+	// 请求的 span 在 flush 之后才结束，flush 是它的子 span
+	assert.Equal(t, root.SpanContext(), byName[web.PhaseFlush].Parent())
+	assert.False(t, byName[web.PhaseFlush].EndTime().After(root.EndTime()))
+	assert.Equal(t, root.SpanContext(), spans[len(spans)-1].SpanContext())
+	assert.Equal(t, byName["auth"].SpanContext(), byName[web.PhaseRoute].Parent())
+	assert.Equal(t, byName["auth"].SpanContext(), byName[web.PhaseHandler].Parent())
+	assert.Equal(t, byName[web.PhaseHandler].SpanContext(), handlerSpan)
+	assert.Equal(t, trace.SpanKindInternal, byName["auth"].SpanKind())
 }
@@ -1,49 +1,164 @@
 package prometheus
 
 import (
-	"gitee.com/geektime-geekbang/geektime-go/web"
-	"github.com/prometheus/client_golang/prometheus"
+	"errors"
+	"net/http"
 	"strconv"
 	"time"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// unknownRoute 没有命中路由的请求都归到这里，
+// 不然扫描器随便发一些路径，就能让指标的数量无限膨胀
+const unknownRoute = "unknown"
+
 type MiddlewareBuilder struct {
 	Namespace string
 	Subsystem string
-	Name      string
+	// Name 响应时间的指标名字，默认是 http_request_duration_seconds
+	Name string
 	Help string
+	// Buckets 响应时间的分桶，单位是秒，默认是 prometheus.DefBuckets
+	Buckets []float64
+	// Objectives 不是 nil 的话，响应时间使用 Summary 而不是 Histogram
+	// Summary 没有办法跨实例聚合，一般不推荐
+	Objectives map[float64]float64
+	// SizeBuckets 请求和响应大小的分桶，单位是字节，默认是 100B 到 100MB
+	SizeBuckets []float64
+	// ConstLabels 固定的标签，比如说 {"service": "user"}
+	ConstLabels prometheus.Labels
+	// Registerer 默认是 prometheus.DefaultRegisterer
+	// 多次 Build 的时候，会复用已经注册的指标
+	Registerer prometheus.Registerer
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
-	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Name: m.Name,
-		Subsystem: m.Subsystem,
-		Namespace: m.Name,
-		Help: m.Help,
-		Objectives: map[float64]float64{
-			0.5: 0.01,
-			0.75: 0.01,
-			0.90: 0.01,
-			0.99: 0.001,
-			0.999: 0.0001,
-		},
-	}, []string{"pattern", "method", "status"})
-
-	prometheus.MustRegister(vector)
+	if m.Name == "" {
+		m.Name = "http_request_duration_seconds"
+	}
+	if m.Help == "" {
+		m.Help = "HTTP 请求的响应时间，单位是秒"
+	}
+	if m.Buckets == nil {
+		m.Buckets = prometheus.DefBuckets
+	}
+	if m.SizeBuckets == nil {
+		m.SizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+	}
+	if m.Registerer == nil {
+		m.Registerer = prometheus.DefaultRegisterer
+	}
+
+	var duration prometheus.ObserverVec
+	if m.Objectives != nil {
+		duration = register(m.Registerer, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:   m.Namespace,
+			Subsystem:   m.Subsystem,
+			Name:        m.Name,
+			Help:        m.Help,
+			ConstLabels: m.ConstLabels,
+			Objectives:  m.Objectives,
+		}, []string{"pattern", "method", "status"}))
+	} else {
+		duration = register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   m.Namespace,
+			Subsystem:   m.Subsystem,
+			Name:        m.Name,
+			Help:        m.Help,
+			ConstLabels: m.ConstLabels,
+			Buckets:     m.Buckets,
+		}, []string{"pattern", "method", "status"}))
+	}
+	inFlight := register(m.Registerer, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        "http_requests_in_flight",
+		Help:        "正在处理的 HTTP 请求数量",
+		ConstLabels: m.ConstLabels,
+	}))
+	reqSize := register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        "http_request_size_bytes",
+		Help:        "HTTP 请求体的大小，单位是字节",
+		ConstLabels: m.ConstLabels,
+		Buckets:     m.SizeBuckets,
+	}, []string{"pattern", "method"}))
+	respSize := register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        "http_response_size_bytes",
+		Help:        "HTTP 响应体的大小，单位是字节",
+		ConstLabels: m.ConstLabels,
+		Buckets:     m.SizeBuckets,
+	}, []string{"pattern", "method", "status"}))
 
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			startTime := time.Now()
+			inFlight.Inc()
 			defer func() {
-				duration := time.Now().Sub(startTime).Milliseconds()
+				inFlight.Dec()
 				pattern := ctx.MatchedRoute
 				if pattern == "" {
-					pattern = "unknown"
+					pattern = unknownRoute
 				}
-				vector.WithLabelValues(pattern, ctx.Req.Method,
-					strconv.Itoa(ctx.RespStatusCode)).Observe(float64(duration))
+				method := normalizeMethod(ctx.Req.Method)
+				status := ctx.RespStatusCode
+				if status == 0 {
+					status = http.StatusOK
+				}
+				code := strconv.Itoa(status)
+				duration.WithLabelValues(pattern, method, code).Observe(time.Since(startTime).Seconds())
+				size := ctx.Req.ContentLength
+				if size < 0 {
+					// chunked 的请求不知道大小
+					size = 0
+				}
+				reqSize.WithLabelValues(pattern, method).Observe(float64(size))
+				respSize.WithLabelValues(pattern, method, code).Observe(float64(ctx.RespSize()))
 			}()
 			next(ctx)
 		}
 	}
 }
+
+// Handler 暴露指标的 handler，例如
+//
+//	server.Get("/metrics", prometheus.Handler(registry))
+//
+// g 为 nil 的时候使用 prometheus.DefaultGatherer
+func Handler(g prometheus.Gatherer) web.HandleFunc {
+	if g == nil {
+		g = prometheus.DefaultGatherer
+	}
+	h := promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+	return func(ctx *web.Context) {
+		h.ServeHTTP(ctx.Resp, ctx.Req)
+	}
+}
+
+// normalizeMethod 方法也是客户端可以随便指定的，非标准的方法都归到 other
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// register 已经注册过的话，返回已经注册的那个
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+		return are.ExistingCollector.(T)
+	}
+	return c
+}
@@ -0,0 +1,32 @@
+//go:build e2e
+package prometheus
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	builder := MiddlewareBuilder{
+		Namespace: "geekbang",
+		Subsystem: "web",
+		Name: "http_response",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+
+	server.Get("/user", func(ctx *web.Context) {
+		val := rand.Intn(1000) + 1
+		time.Sleep(time.Duration(val) * time.Millisecond)
+		ctx.RespJSON(200, User{Name: "Tom"})
+	})
+
+	server.Get("/metrics", Handler(nil))
+
+	server.Start(":8081")
+}
+
+type User struct {
+	Name string
+}
@@ -1,37 +1,74 @@
-//go:build e2e
 package prometheus
 
 import (
-	"gitee.com/geektime-geekbang/geektime-go/web"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestMiddlewareBuilder_Build(t *testing.T) {
+func TestMiddlewareBuilder_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
 	builder := MiddlewareBuilder{
-		Namespace: "geekbang",
-		Subsystem: "web",
-		Name: "http_response",
+		Namespace:   "geekbang",
+		Subsystem:   "web",
+		Buckets:     []float64{0.1, 1},
+		SizeBuckets: []float64{10, 100},
+		ConstLabels: prometheus.Labels{"service": "user"},
+		Registerer:  reg,
 	}
+	var inFlight float64
 	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
-
-	server.Get("/user", func(ctx *web.Context) {
-		val := rand.Intn(1000) + 1
-		time.Sleep(time.Duration(val) * time.Millisecond)
-		ctx.RespJSON(200, User{Name: "Tom"})
+	server.Post("/user/:id", func(ctx *web.Context) {
+		inFlight = gaugeValue(t, reg, "geekbang_web_http_requests_in_flight")
+		ctx.RespData = []byte("hello, world")
 	})
+	server.Get("/metrics", Handler(reg))
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/user/1", strings.NewReader("12345")))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/user/2", nil))
+	// 没有命中路由和奇怪的方法都会被归类，不会让指标膨胀
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wp-admin.php", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/a/b/c", nil))
+	assert.Equal(t, float64(1), inFlight)
 
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.ListenAndServe(":8082", nil)
-	}()
+	// 多次 Build 不会 panic，而是复用已经注册的指标
+	assert.NotPanics(t, func() {
+		builder.Build()
+	})
 
-	server.Start(":8081")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`geekbang_web_http_request_duration_seconds_count{method="POST",pattern="/user/:id",service="user",status="200"} 2`,
+		`geekbang_web_http_request_duration_seconds_bucket{method="GET",pattern="unknown",service="user",status="404",le="1"} 1`,
+		`geekbang_web_http_request_duration_seconds_count{method="other",pattern="unknown",service="user",status="404"} 1`,
+		`geekbang_web_http_request_size_bytes_sum{method="POST",pattern="/user/:id",service="user"} 5`,
+		`geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",service="user",status="200",le="10"} 0`,
+		`geekbang_web_http_response_size_bytes_sum{method="POST",pattern="/user/:id",service="user",status="200"} 24`,
+		`geekbang_web_http_requests_in_flight{service="user"} 1`,
+	} {
+		assert.Contains(t, body, want)
+	}
+	assert.NotContains(t, body, "wp-admin")
 }
 
-type User struct {
-	Name string
-}
\ No newline at end of file
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("没有找到指标 %s", name)
+	return 0
+}
@@ -1,26 +1,206 @@
 package recover
 
-import "gitee.com/geektime-geekbang/geektime-go/web"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"runtime"
+	"strings"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultRedactHeaders 默认脱敏的请求头
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
 
 type MiddlewareBuilder struct {
+	// StatusCode 默认是 500
 	StatusCode int
 	Data []byte
-	// log func(err any)
-	Log func(ctx *web.Context)
-	// log func(stack string)
+	// Log 不是 nil 的话，每次 panic 都会调用
+	// http.ErrAbortHandler 是主动中断请求，不会调用 Log
+	Log func(ctx *web.Context, info *PanicInfo)
+	// StackDepth 最多记录多少层调用栈，默认是 32，小于 0 代表不记录
+	StackDepth int
+	// DumpRequest 是否把请求放到 PanicInfo 里面，请求体不会被记录
+	DumpRequest bool
+	// RedactHeaders 记录请求的时候需要脱敏的请求头，默认是 Authorization, Cookie 之类的
+	RedactHeaders []string
+	// RepanicOnAbort 遇到 http.ErrAbortHandler 的时候继续 panic，
+	// 让 net/http 直接断开连接，客户端就知道响应是不完整的
+	RepanicOnAbort bool
+	// Registerer 用来注册 panic 计数的 Counter panics_total，为 nil 的时候不统计
+	// 需要注册到全局的话，使用 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+	Namespace  string
+	Subsystem  string
+}
+
+// PanicInfo 发生 panic 的时候的现场
+// 它会被放到 ctx.HandlerErr 上，外层的 middleware 可以通过 errors.As 拿到
+type PanicInfo struct {
+	// Value recover 拿到的值
+	Value any
+	Stack []byte
+	// Route 命中的路由，没有命中的时候是空字符串
+	Route     string
+	Method    string
+	Path      string
+	RequestID string
+	// Request 脱敏之后的请求，只有 DumpRequest 为 true 的时候才有
+	Request []byte
+}
+
+func (p *PanicInfo) Error() string {
+	return fmt.Sprintf("recover: %v", p.Value)
+}
+
+// Unwrap panic 的值是 error 的时候，可以用 errors.Is 判断
+func (p *PanicInfo) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusInternalServerError
+	}
+	if m.StackDepth == 0 {
+		m.StackDepth = 32
+	}
+	if m.RedactHeaders == nil {
+		m.RedactHeaders = defaultRedactHeaders
+	}
+	counter := m.counter()
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			defer func() {
-				if err := recover(); err != nil {
-					ctx.RespData = m.Data
-					ctx.RespStatusCode = m.StatusCode
-					m.Log(ctx)
+				val := recover()
+				if val == nil {
+					return
+				}
+				if val == http.ErrAbortHandler {
+					if m.RepanicOnAbort {
+						panic(val)
+					}
+					ctx.HandlerErr = http.ErrAbortHandler
+					m.respond(ctx)
+					return
+				}
+				info := m.panicInfo(ctx, val)
+				ctx.HandlerErr = info
+				m.respond(ctx)
+
+				route := info.Route
+				if route == "" {
+					route = "unknown"
+				}
+				if counter != nil {
+					counter.WithLabelValues(route, info.Method).Inc()
+				}
+				span := trace.SpanFromContext(ctx.Req.Context())
+				span.RecordError(info, trace.WithAttributes(
+					attribute.String("exception.stacktrace", string(info.Stack))))
+				span.SetStatus(codes.Error, info.Error())
+				if m.Log != nil {
+					m.Log(ctx, info)
 				}
 			}()
 			next(ctx)
 		}
 	}
 }
+
+// respond 流式响应已经发送出去的话，就不能再覆盖了
+func (m MiddlewareBuilder) respond(ctx *web.Context) {
+	if ctx.RespCommitted() {
+		return
+	}
+	ctx.RespData = m.Data
+	ctx.RespStatusCode = m.StatusCode
+}
+
+func (m MiddlewareBuilder) counter() *prometheus.CounterVec {
+	if m.Registerer == nil {
+		return nil
+	}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Subsystem: m.Subsystem,
+		Name:      "panics_total",
+		Help:      "handler 发生 panic 的次数",
+	}, []string{"pattern", "method"})
+	if err := m.Registerer.Register(counter); err != nil {
+		// 多次 Build 的时候，复用已经注册的
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	return counter
+}
+
+func (m MiddlewareBuilder) panicInfo(ctx *web.Context, val any) *PanicInfo {
+	info := &PanicInfo{
+		Value:     val,
+		Route:     ctx.MatchedRoute,
+		Method:    ctx.Req.Method,
+		Path:      ctx.Req.URL.Path,
+		RequestID: ctx.RequestID(),
+	}
+	if m.StackDepth > 0 {
+		info.Stack = stack(m.StackDepth)
+	}
+	if m.DumpRequest {
+		info.Request = m.dump(ctx.Req)
+	}
+	return info
+}
+
+// dump 敏感的请求头会被替换为 [REDACTED]
+func (m MiddlewareBuilder) dump(req *http.Request) []byte {
+	cp := *req
+	cp.Header = req.Header.Clone()
+	for _, key := range m.RedactHeaders {
+		if _, ok := cp.Header[http.CanonicalHeaderKey(key)]; ok {
+			cp.Header.Set(key, "[REDACTED]")
+		}
+	}
+	data, err := httputil.DumpRequest(&cp, false)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// stack 从发生 panic 的地方开始，最多 depth 层
+func stack(depth int) []byte {
+	pcs := make([]uintptr, depth+32)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var sb strings.Builder
+	// runtime.gopanic 以及之前的都是 recover 自己的调用栈，
+	// 之后的 runtime.sigpanic 之类的也不需要
+	inPanic := true
+	for cnt := 0; cnt < depth; {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			inPanic = false
+		case inPanic || strings.HasPrefix(frame.Function, "runtime.") && cnt == 0:
+		default:
+			fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+			cnt++
+		}
+		if !more {
+			break
+		}
+	}
+	return []byte(sb.String())
+}
@@ -1,8 +1,17 @@
 package recover
 
 import (
+	"errors"
 	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -10,8 +19,8 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	builder := MiddlewareBuilder{
 		StatusCode: 500,
 		Data: []byte("你 panic 了"),
-		Log: func(ctx *web.Context) {
-			fmt.Printf("panic 路径: %s", ctx.Req.URL.String())
+		Log: func(ctx *web.Context, info *PanicInfo) {
+			fmt.Printf("panic 路径: %s, %v\n%s", ctx.Req.URL.String(), info.Value, info.Stack)
 		},
 	}
 
@@ -21,3 +30,111 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	})
 	server.Start(":8081")
 }
+
+func TestMiddlewareBuilder_Panic(t *testing.T) {
+	var info *PanicInfo
+	builder := MiddlewareBuilder{
+		Data:        []byte("你 panic 了"),
+		DumpRequest: true,
+		Registerer:  prometheus.NewRegistry(),
+		Log: func(ctx *web.Context, pi *PanicInfo) {
+			info = pi
+		},
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user/:id", func(ctx *web.Context) {
+		panicUser()
+	})
+	server.Get("/abort", func(ctx *web.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	server.Get("/stream", func(ctx *web.Context) {
+		ctx.SetRespMode(web.RespModeStreaming)
+		_, _ = ctx.Resp.Write([]byte("hello"))
+		panic("写了一半")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/user/12", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "req-1")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "你 panic 了", recorder.Body.String())
+	assert.Equal(t, "数据库挂了", info.Value)
+	assert.Equal(t, "/user/:id", info.Route)
+	assert.Equal(t, "req-1", info.RequestID)
+	// 调用栈从发生 panic 的函数开始
+	assert.True(t, strings.HasPrefix(string(info.Stack), "gitee.com/geektime-geekbang/geektime-go/web/middlewares/recover.panicUser\n"))
+	assert.Contains(t, string(info.Request), "Authorization: [REDACTED]")
+	assert.NotContains(t, string(info.Request), "secret")
+
+	// 主动中断不是 bug，不会记录
+	info = nil
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/abort", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Nil(t, info)
+
+	// 已经发送出去的响应不会被覆盖
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+	assert.Equal(t, "写了一半", info.Value)
+
+	builder.RepanicOnAbort = true
+	server = web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/abort", func(ctx *web.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	})
+}
+
+func TestMiddlewareBuilder_Telemetry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	builder := MiddlewareBuilder{Registerer: reg, Namespace: "geekbang"}
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	server := web.NewHTTPServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				reqCtx, span := tracer.Start(ctx.Req.Context(), "request")
+				defer span.End()
+				ctx.Req = ctx.Req.WithContext(reqCtx)
+				next(ctx)
+			}
+		}, builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		panic(errors.New("数据库挂了"))
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	spans := recorder.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "geekbang_panics_total", mfs[0].GetName())
+	assert.Equal(t, float64(1), mfs[0].GetMetric()[0].GetCounter().GetValue())
+
+	// 没有 Registerer 的时候不统计，也不会注册到全局
+	MiddlewareBuilder{}.Build()
+	mfs, err = prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		assert.NotEqual(t, "panics_total", mf.GetName())
+	}
+}
+
+func panicUser() {
+	panic("数据库挂了")
+}
@@ -0,0 +1,153 @@
+package servertiming
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+// TimingTotal 整个请求的耗时，从框架开始处理请求到发送响应头
+const TimingTotal = "total"
+
+// Policy 决定要不要把 Server-Timing 暴露给这个请求
+// 耗时会泄露后端的实现细节，所以默认只暴露给内网
+type Policy func(ctx *web.Context) bool
+
+// Always 暴露给所有的请求
+func Always() Policy {
+	return func(ctx *web.Context) bool {
+		return true
+	}
+}
+
+// PrivateIP 客户端是内网或者本机的 IP
+// 经过代理的时候，要配合 web.ServerWithTrustedProxies 和 web.ServerWithForwardedHeader 使用，
+// 不然拿到的是代理的 IP
+func PrivateIP() Policy {
+	return func(ctx *web.Context) bool {
+		ip := net.ParseIP(ctx.ClientIP())
+		return ip != nil && (ip.IsPrivate() || ip.IsLoopback())
+	}
+}
+
+// Header 请求带了调试用的请求头，value 为空的时候只要有这个请求头就可以
+func Header(name, value string) Policy {
+	return func(ctx *web.Context) bool {
+		vals := ctx.Req.Header.Values(name)
+		if value == "" {
+			return len(vals) > 0
+		}
+		for _, val := range vals {
+			if val == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Any 任意一个 Policy 通过就暴露
+func Any(policies ...Policy) Policy {
+	return func(ctx *web.Context) bool {
+		for _, p := range policies {
+			if p(ctx) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type MiddlewareBuilder struct {
+	// Policy 默认是 PrivateIP
+	Policy Policy
+}
+
+// Build 在发送响应头之前把 ctx.Timing 记录的耗时，
+// 以及框架记录的路由和总耗时写到 Server-Timing 响应头里面。
+// 流式响应在第一次写入的时候就发送了响应头，所以只包含在此之前结束的阶段
+func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.Policy == nil {
+		m.Policy = PrivateIP()
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if !m.Policy(ctx) {
+				next(ctx)
+				return
+			}
+			ctx.BeforeCommit(func() {
+				total := web.Timing{Name: TimingTotal, Done: true}
+				if start := ctx.StartTime(); !start.IsZero() {
+					total.Duration = time.Since(start)
+				}
+				ctx.RespHeader().Add("Server-Timing", Format(append(ctx.Timings(), total)...))
+			})
+			next(ctx)
+		}
+	}
+}
+
+// Format 按照 Server-Timing 的格式输出，比如说
+// route;dur=0.012, db;dur=12.5;desc="查询用户", total;dur=13.2
+// dur 的单位是毫秒，还没有结束的阶段没有 dur
+func Format(timings ...web.Timing) string {
+	var sb strings.Builder
+	for i, t := range timings {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(token(t.Name))
+		if t.Done {
+			sb.WriteString(";dur=")
+			sb.WriteString(strconv.FormatFloat(float64(t.Duration.Microseconds())/1000, 'f', -1, 64))
+		}
+		if t.Desc != "" {
+			sb.WriteString(";desc=")
+			sb.WriteString(quote(t.Desc))
+		}
+	}
+	return sb.String()
+}
+
+// token 名字只能是 RFC 7230 的 token，其余的字符替换成下划线
+func token(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && isTokenChar(byte(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// quote 输出 quoted-string，控制字符会被去掉，不然响应头就被截断了
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,145 @@
+package servertiming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	builder := MiddlewareBuilder{
+		Policy: Any(PrivateIP(), Header("X-Debug", "1")),
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		stop := ctx.Timing("db", `查询"用户"`)
+		time.Sleep(time.Millisecond)
+		stop()
+		// 没有结束的阶段
+		ctx.Timing("cache", "")
+		ctx.RespData = []byte("hello")
+	})
+	server.Get("/stream", func(ctx *web.Context) {
+		ctx.Timing("before", "")()
+		_, _ = ctx.Resp.Write([]byte("a"))
+		ctx.Resp.(http.Flusher).Flush()
+		ctx.Timing("after", "")()
+	})
+
+	testCases := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		header     map[string]string
+
+		wantTiming *regexp.Regexp
+	}{
+		{
+			name:       "private ip",
+			path:       "/user",
+			remoteAddr: "10.0.0.1:1234",
+			wantTiming: regexp.MustCompile(`^route;dur=[0-9.]+, db;dur=[0-9.]+;desc="查询\\"用户\\"", cache, total;dur=[0-9.]+$`),
+		},
+		{
+			name:       "public ip",
+			path:       "/user",
+			remoteAddr: "8.8.8.8:1234",
+		},
+		{
+			name:       "debug header",
+			path:       "/user",
+			remoteAddr: "8.8.8.8:1234",
+			header:     map[string]string{"X-Debug": "1"},
+			wantTiming: regexp.MustCompile(`^route;dur=[0-9.]+, db;dur=`),
+		},
+		{
+			name:       "wrong debug header",
+			path:       "/user",
+			remoteAddr: "8.8.8.8:1234",
+			header:     map[string]string{"X-Debug": "0"},
+		},
+		{
+			// 流式响应在第一次写入的时候就发送了响应头
+			name:       "stream",
+			path:       "/stream",
+			remoteAddr: "127.0.0.1:1234",
+			wantTiming: regexp.MustCompile(`^route;dur=[0-9.]+, before;dur=[0-9.]+, total;dur=[0-9.]+$`),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			timing := recorder.Header().Values("Server-Timing")
+			if tc.wantTiming == nil {
+				assert.Empty(t, timing)
+				return
+			}
+			assert.Equal(t, 1, len(timing))
+			assert.Regexp(t, tc.wantTiming, timing[0])
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	res := Format(
+		web.Timing{Name: "db query", Duration: 12345 * time.Microsecond, Done: true},
+		web.Timing{Name: "cache", Desc: "a\r\nb", Duration: time.Millisecond, Done: true},
+		web.Timing{Name: "", Done: false},
+	)
+	assert.Equal(t, `db_query;dur=12.345, cache;dur=1;desc="ab", _`, res)
+}
+
+func TestPrivateIP(t *testing.T) {
+	server := web.NewHTTPServer(
+		web.ServerWithTrustedProxies("10.0.0.0/8"),
+		web.ServerWithMiddleware(MiddlewareBuilder{}.Build()))
+	server.Get("/user", func(ctx *web.Context) {})
+
+	testCases := []struct {
+		name   string
+		header map[string]string
+
+		wantExposed bool
+	}{
+		{
+			name:        "internal client",
+			header:      map[string]string{"X-Forwarded-For": "192.168.1.2"},
+			wantExposed: true,
+		},
+		{
+			// 代理只追加 X-Forwarded-For，客户端伪造的 Forwarded 没有用
+			name: "injected forwarded",
+			header: map[string]string{
+				"Forwarded":       "for=10.0.0.1",
+				"X-Forwarded-For": "8.8.8.8",
+			},
+		},
+		{
+			name:   "injected x-forwarded-for",
+			header: map[string]string{"X-Forwarded-For": "10.0.0.1, 8.8.8.8"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			req.RemoteAddr = "10.0.0.2:1234"
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantExposed, recorder.Header().Get("Server-Timing") != "")
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// 框架自身的阶段
+const (
+	// PhaseRoute 查找路由
+	PhaseRoute = "web.route"
+	// PhaseHandler 执行业务的 handler，不包括 middleware
+	PhaseHandler = "web.handler"
+	// PhaseFlush 把响应发送出去
+	PhaseFlush = "web.flush"
+)
+
+// PhaseHook 在每一个阶段开始的时候调用，返回的函数在阶段结束的时候调用，
+// 即便发生了 panic 也会调用。
+// 阶段包括每一个 middleware，不管是 HTTPServer 上的还是路由上的，以及 PhaseRoute 这些框架自身的阶段。
+// middleware 是嵌套的，所以 middleware 的阶段包含了它后面所有的阶段
+type PhaseHook func(ctx *Context, name string) (end func())
+
+// ServerWithPhaseHook 设置 PhaseHook，比如说用来给每一个阶段创建一个 span，
+// 这样就能知道慢的请求到底慢在哪里
+func ServerWithPhaseHook(hook PhaseHook) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.phaseHook = hook
+	}
+}
+
+// Named 给 middleware 一个名字，作为 PhaseHook 的 name
+// 没有名字的 middleware 使用函数的名字，比如说 accesslog.MiddlewareBuilder.Build.func1
+func Named(name string, m Middleware) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		h := m(next)
+		return func(ctx *Context) {
+			ctx.phase(name, h)
+		}
+	}
+}
+
+// namedPC Named 返回的闭包的代码地址，同一个函数字面量创建的闭包都是一样的
+var namedPC = reflect.ValueOf(Named("", nil)).Pointer()
+
+// phase 没有 PhaseHook 的时候直接执行 h
+func (c *Context) phase(name string, h HandleFunc) {
+	if c.server == nil || c.server.phaseHook == nil {
+		h(c)
+		return
+	}
+	end := c.server.phaseHook(c, name)
+	defer end()
+	h(c)
+}
+
+// chain 组装 middleware，有 PhaseHook 的时候，每一个 middleware 都是一个阶段
+func (h *HTTPServer) chain(mdls []Middleware, root HandleFunc) HandleFunc {
+	for i := len(mdls) - 1; i >= 0; i-- {
+		m := mdls[i]
+		next := m(root)
+		if h.phaseHook == nil || reflect.ValueOf(m).Pointer() == namedPC {
+			root = next
+			continue
+		}
+		name := middlewareName(m)
+		root = func(ctx *Context) {
+			ctx.phase(name, next)
+		}
+	}
+	return root
+}
+
+// middlewareNames 代码地址 => 名字
+var middlewareNames sync.Map
+
+// middlewareName 去掉包路径，但是保留末尾的 .func1 之类的序号，
+// 不然同一个函数里面的多个匿名 middleware 就分不清了，比如说
+// gitee.com/geektime-geekbang/geektime-go/web/middlewares/accesslog.MiddlewareBuilder.Build.func1
+// 就是 accesslog.MiddlewareBuilder.Build.func1。想要更好读的名字，用 Named
+func middlewareName(m Middleware) string {
+	pc := reflect.ValueOf(m).Pointer()
+	if name, ok := middlewareNames.Load(pc); ok {
+		return name.(string)
+	}
+	name := "middleware"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+	}
+	middlewareNames.Store(pc, name)
+	return name
+}
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMiddleware(next HandleFunc) HandleFunc {
+	return func(ctx *Context) {
+		next(ctx)
+	}
+}
+
+func TestServerWithPhaseHook(t *testing.T) {
+	var phases []string
+	hook := func(ctx *Context, name string) func() {
+		phases = append(phases, "start "+name)
+		return func() {
+			phases = append(phases, "end "+name)
+		}
+	}
+	server := NewHTTPServer(
+		ServerWithMiddleware(Named("auth", testMiddleware), testMiddleware),
+		ServerWithPhaseHook(hook))
+	server.addRoute(http.MethodGet, "/user", func(ctx *Context) {
+		phases = append(phases, "handler")
+	}, func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+		}
+	}, func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+		}
+	})
+	server.addRoute(http.MethodGet, "/panic", func(ctx *Context) {
+		panic("boom")
+	})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, []string{
+		"start auth",
+		"start web.testMiddleware",
+		"start web.route",
+		"end web.route",
+		// 路由上的 middleware 在找到路由之后才执行，匿名的 middleware 用序号区分
+		"start web.TestServerWithPhaseHook.func3",
+		"start web.TestServerWithPhaseHook.func4",
+		"start web.handler",
+		"handler",
+		"end web.handler",
+		"end web.TestServerWithPhaseHook.func4",
+		"end web.TestServerWithPhaseHook.func3",
+		"end web.testMiddleware",
+		"end auth",
+		"start web.flush",
+		"end web.flush",
+	}, phases)
+
+	// panic 的时候也会结束阶段
+	phases = nil
+	assert.Panics(t, func() {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	assert.Equal(t, "end auth", phases[len(phases)-1])
+}
+
+func TestNamed(t *testing.T) {
+	// 没有 PhaseHook 的时候只是普通的 middleware
+	var called bool
+	server := NewHTTPServer(ServerWithMiddleware(Named("auth", func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			called = true
+			next(ctx)
+		}
+	})))
+	server.Get("/user", func(ctx *Context) {
+		ctx.RespData = []byte("hello")
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.True(t, called)
+	assert.Equal(t, "hello", recorder.Body.String())
+}
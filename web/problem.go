@@ -0,0 +1,449 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrUnknownProblem Problem 使用了没有注册的错误码
+var ErrUnknownProblem = errors.New("web: 未注册的错误码")
+
+const problemContentType = "application/problem+json"
+
+// defaultProblemRegistry 没有经过 HTTPServer 创建的 Context 使用这个
+var defaultProblemRegistry = NewProblemRegistry()
+
+// ProblemType 错误码目录里面的一项
+// 注册之后 Code, Type 和 Title 就不应该再变了，客户端的 SDK 会依赖它们
+type ProblemType struct {
+	// Code 错误码，比如说 user_not_found，会作为 code 扩展字段返回
+	Code string
+	// Type 描述这个错误的文档地址，为空的时候是 about:blank
+	Type string
+	// Title 简短的描述，不应该随着请求变化
+	Title string
+	// Status 默认的响应码
+	Status int
+	// Details 语言 => detail 的模板
+	// 模板里面可以用 {key} 引用 ProblemField，比如说 "用户 {id} 不存在"
+	Details map[string]string
+}
+
+// ProblemRegistry 错误码目录
+type ProblemRegistry struct {
+	mutex       sync.RWMutex
+	types       map[string]ProblemType
+	defaultLang string
+}
+
+func NewProblemRegistry() *ProblemRegistry {
+	r := &ProblemRegistry{
+		types:       make(map[string]ProblemType, len(defaultProblemTypes)),
+		defaultLang: "zh",
+	}
+	for _, pt := range defaultProblemTypes {
+		r.Register(pt)
+	}
+	return r
+}
+
+// Register 注册错误码，同一个 Code 会被覆盖
+// Title 为空的时候使用 http.StatusText(Status)
+func (r *ProblemRegistry) Register(pt ProblemType) {
+	if pt.Code == "" {
+		panic("web: 错误码不能为空")
+	}
+	if pt.Status < 400 || pt.Status > 599 {
+		panic(fmt.Sprintf("web: 错误码 %s 的响应码 %d 不是错误响应码", pt.Code, pt.Status))
+	}
+	if pt.Title == "" {
+		pt.Title = http.StatusText(pt.Status)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.types[pt.Code] = pt
+}
+
+// Lookup 查找错误码
+func (r *ProblemRegistry) Lookup(code string) (ProblemType, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	pt, ok := r.types[code]
+	return pt, ok
+}
+
+// New 创建错误码对应的 Problem，detail 使用 lang 对应的模板
+// lang 的查找规则和 Validator.Translate 一样。fields 会作为扩展字段返回
+func (r *ProblemRegistry) New(code string, lang string, fields ...ProblemField) (*Problem, error) {
+	pt, ok := r.Lookup(code)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProblem, code)
+	}
+	p := &Problem{
+		Type:       pt.Type,
+		Title:      pt.Title,
+		Status:     pt.Status,
+		Detail:     r.detail(pt, lang, fields),
+		Extensions: map[string]any{"code": pt.Code},
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	for _, f := range fields {
+		p.Set(f.Key, f.Value)
+	}
+	return p, nil
+}
+
+func (r *ProblemRegistry) detail(pt ProblemType, lang string, fields []ProblemField) string {
+	tpl, ok := pt.Details[lang]
+	if !ok {
+		base, _, _ := strings.Cut(lang, "-")
+		tpl, ok = pt.Details[base]
+	}
+	if !ok {
+		tpl = pt.Details[r.defaultLang]
+	}
+	if tpl == "" || len(fields) == 0 {
+		return tpl
+	}
+	oldnew := make([]string, 0, 2*len(fields))
+	for _, f := range fields {
+		oldnew = append(oldnew, "{"+f.Key+"}", fmt.Sprint(f.Value))
+	}
+	return strings.NewReplacer(oldnew...).Replace(tpl)
+}
+
+// ProblemField detail 模板的参数，同时也会作为扩展字段返回
+type ProblemField struct {
+	Key   string
+	Value any
+}
+
+// Field 创建 ProblemField
+// Value 是 ValidationErrors 或者 BindErrors 的时候，会被转换为字段错误的列表
+func Field(key string, val any) ProblemField {
+	return ProblemField{Key: key, Value: val}
+}
+
+// Problem RFC 7807 定义的错误响应
+// Extensions 和标准字段平铺在同一个 JSON 对象里面
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Extensions 扩展字段，比如说 code, errors, trace_id
+	Extensions map[string]any `json:"-"`
+}
+
+// NewProblem 没有错误码的 Problem，type 是 about:blank，title 是 http.StatusText(status)
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Set 设置扩展字段，和标准字段同名的会被忽略
+func (p *Problem) Set(key string, val any) {
+	switch key {
+	case "type", "title", "status", "detail", "instance":
+		return
+	}
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any, 4)
+	}
+	p.Extensions[key] = val
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	data, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+	keys := make([]string, 0, len(p.Extensions))
+	for key := range p.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// 去掉最后的 }，然后按照 key 的顺序追加扩展字段
+	res := data[:len(data)-1]
+	for _, key := range keys {
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(p.Extensions[key])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ',')
+		res = append(res, k...)
+		res = append(res, ':')
+		res = append(res, v...)
+	}
+	return append(res, '}'), nil
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	type problem Problem
+	if err := json.Unmarshal(data, (*problem)(p)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	p.Extensions = nil
+	for key, val := range fields {
+		p.Set(key, val)
+	}
+	return nil
+}
+
+// ServerWithProblemRegistry 替换掉默认的错误码目录
+func ServerWithProblemRegistry(r *ProblemRegistry) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.problems = r
+	}
+}
+
+// RegisterProblem 注册错误码，之后就可以通过 ctx.Problem 使用
+func (h *HTTPServer) RegisterProblem(pt ProblemType) {
+	if h.problems == nil {
+		h.problems = NewProblemRegistry()
+	}
+	h.problems.Register(pt)
+}
+
+// Problems 返回服务器上的错误码目录
+func (c *Context) Problems() *ProblemRegistry {
+	if c.server == nil || c.server.problems == nil {
+		return defaultProblemRegistry
+	}
+	return c.server.problems
+}
+
+// Problem 以 application/problem+json 响应错误码对应的错误
+// detail 按照 Accept-Language 选择语言，此外还会带上 instance, trace_id 和 request_id。
+// 错误码没有注册的时候响应 500，并且返回 ErrUnknownProblem
+func (c *Context) Problem(code string, fields ...ProblemField) error {
+	p, err := c.Problems().New(code, c.PreferredLanguage(), fields...)
+	if err != nil {
+		p = NewProblem(http.StatusInternalServerError, "")
+	}
+	if werr := c.WriteProblem(p); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// WriteProblem 响应 p
+// 字段错误会被转换为 Accept-Language 对应的语言
+func (c *Context) WriteProblem(p *Problem) error {
+	if p.Instance == "" {
+		p.Instance = c.Req.URL.Path
+	}
+	for key, val := range p.Extensions {
+		switch v := val.(type) {
+		case ValidationErrors:
+			p.Extensions[key] = c.Validator().Translate(v, c.PreferredLanguage())
+		case BindErrors:
+			p.Extensions[key] = problemBindErrors(v)
+		}
+	}
+	if sc := trace.SpanContextFromContext(c.Req.Context()); sc.HasTraceID() {
+		p.Set("trace_id", sc.TraceID().String())
+	}
+	if id := c.RequestID(); id != "" {
+		p.Set("request_id", id)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	c.Data(p.Status, contentType(problemContentType), data)
+	return nil
+}
+
+// ProblemErrorHandler 以 application/problem+json 响应错误的 ErrorHandler
+// 可以通过 ServerWithErrorHandler(ProblemErrorHandler) 替换掉 DefaultErrorHandler
+func ProblemErrorHandler(ctx *Context, err error) {
+	if ctx.RespCommitted() {
+		return
+	}
+	_ = ctx.WriteProblem(ctx.ErrorProblem(err))
+}
+
+// ErrorProblem 把 err 转换为 Problem
+// HTTPError 的 Code 注册过的话，使用注册的错误码，但是响应码以 HTTPError 为准，Message 不为空的话作为 detail。
+// 校验和绑定的错误会带上字段错误的列表，其余的按照 ErrorStatus 使用响应码对应的错误码
+func (c *Context) ErrorProblem(err error) *Problem {
+	lang := c.PreferredLanguage()
+	problems := c.Problems()
+	var httpErr *HTTPError
+	var verrs ValidationErrors
+	var berrs BindErrors
+	switch {
+	case errors.As(err, &httpErr):
+		p, _ := problems.New(httpErr.Code, lang)
+		if p == nil {
+			// 和 DefaultErrorHandler 一样，没有响应码的就是 500
+			status := httpErr.Status
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			p = NewProblem(status, httpErr.Message)
+			if httpErr.Code != "" {
+				p.Set("code", httpErr.Code)
+			}
+			return p
+		}
+		if httpErr.Status != 0 && httpErr.Status != p.Status {
+			// 注册的 title 是对应注册的响应码的
+			p.Status = httpErr.Status
+			p.Title = http.StatusText(p.Status)
+		}
+		// NewHTTPError 在 message 为空的时候填的是 http.StatusText，这种不算
+		if httpErr.Message != "" && httpErr.Message != http.StatusText(httpErr.Status) {
+			p.Detail = httpErr.Message
+		}
+		return p
+	case errors.As(err, &verrs):
+		if p, _ := problems.New("validation_failed", lang, Field("errors", verrs)); p != nil {
+			return p
+		}
+	case errors.As(err, &berrs):
+		if p, _ := problems.New("bad_request", lang, Field("errors", berrs)); p != nil {
+			return p
+		}
+	}
+	status := ErrorStatus(err)
+	p := c.StatusProblem(status)
+	if status < 500 {
+		// 5xx 的错误不能暴露给客户端
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// StatusProblem 响应码默认的错误码对应的 Problem，比如说 404 对应 not_found
+// 错误码目录里面没有的话，type 是 about:blank，没有 detail
+func (c *Context) StatusProblem(status int) *Problem {
+	p, _ := c.Problems().New(problemCodes[status], c.PreferredLanguage())
+	if p == nil {
+		p = NewProblem(status, "")
+	}
+	return p
+}
+
+type problemBindError struct {
+	Field   string `json:"field,omitempty"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+func problemBindErrors(errs BindErrors) []problemBindError {
+	res := make([]problemBindError, 0, len(errs))
+	for _, fe := range errs {
+		res = append(res, problemBindError{Field: fe.Field, Source: fe.Source, Message: fe.Error()})
+	}
+	return res
+}
+
+// PreferredLanguage 取 Accept-Language 里面 q 值最高的语言，q 值一样的按照出现的顺序
+// 没有 Accept-Language，或者只有 * 的时候返回空字符串
+func (c *Context) PreferredLanguage() string {
+	var res string
+	best := 0.0
+	for _, part := range strings.Split(c.Req.Header.Get("Accept-Language"), ",") {
+		lang, params, _ := strings.Cut(part, ";")
+		lang = strings.TrimSpace(lang)
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if key, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(val), 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			res, best = lang, q
+		}
+	}
+	return res
+}
+
+// problemCodes 响应码 => 默认的错误码
+var problemCodes = map[int]string{}
+
+func init() {
+	for _, pt := range defaultProblemTypes {
+		if _, ok := problemCodes[pt.Status]; !ok {
+			problemCodes[pt.Status] = pt.Code
+		}
+	}
+}
+
+var defaultProblemTypes = []ProblemType{
+	{Code: "bad_request", Status: http.StatusBadRequest, Details: map[string]string{
+		"zh": "请求参数不合法",
+		"en": "The request is malformed",
+	}},
+	{Code: "unauthorized", Status: http.StatusUnauthorized, Details: map[string]string{
+		"zh": "请先登录",
+		"en": "Authentication is required",
+	}},
+	{Code: "forbidden", Status: http.StatusForbidden, Details: map[string]string{
+		"zh": "没有权限",
+		"en": "You do not have permission to access this resource",
+	}},
+	{Code: "not_found", Status: http.StatusNotFound, Details: map[string]string{
+		"zh": "资源不存在",
+		"en": "The requested resource was not found",
+	}},
+	{Code: "method_not_allowed", Status: http.StatusMethodNotAllowed, Details: map[string]string{
+		"zh": "不支持该请求方法",
+		"en": "The request method is not supported",
+	}},
+	{Code: "not_acceptable", Status: http.StatusNotAcceptable, Details: map[string]string{
+		"zh": "没有可以接受的响应格式",
+		"en": "No acceptable representation is available",
+	}},
+	{Code: "conflict", Status: http.StatusConflict, Details: map[string]string{
+		"zh": "资源冲突",
+		"en": "The request conflicts with the current state of the resource",
+	}},
+	{Code: "unsupported_media_type", Status: http.StatusUnsupportedMediaType, Details: map[string]string{
+		"zh": "不支持的请求格式",
+		"en": "The request content type is not supported",
+	}},
+	{Code: "validation_failed", Status: http.StatusUnprocessableEntity, Details: map[string]string{
+		"zh": "请求参数校验失败",
+		"en": "The request failed validation",
+	}},
+	{Code: "too_many_requests", Status: http.StatusTooManyRequests, Details: map[string]string{
+		"zh": "请求太频繁，请稍后再试",
+		"en": "Too many requests, please try again later",
+	}},
+	{Code: "internal", Status: http.StatusInternalServerError, Details: map[string]string{
+		"zh": "服务器内部错误",
+		"en": "An internal error occurred",
+	}},
+	{Code: "service_unavailable", Status: http.StatusServiceUnavailable, Details: map[string]string{
+		"zh": "服务暂时不可用",
+		"en": "The service is temporarily unavailable",
+	}},
+}
@@ -0,0 +1,232 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContext_Problem(t *testing.T) {
+	server := NewHTTPServer()
+	server.RegisterProblem(ProblemType{
+		Code:   "user_not_found",
+		Type:   "https://example.com/problems/user-not-found",
+		Title:  "User not found",
+		Status: http.StatusNotFound,
+		Details: map[string]string{
+			"zh": "用户 {id} 不存在",
+			"en": "User {id} does not exist",
+		},
+	})
+	server.GetE("/users/:id", func(ctx *Context) error {
+		return ctx.Problem("user_not_found", Field("id", ctx.PathParams["id"]))
+	})
+	server.PostE("/users", func(ctx *Context) error {
+		var req struct {
+			Name string `json:"name" validate:"required"`
+		}
+		if err := ctx.BindAndValidate(&req); err != nil {
+			return ctx.Problem("validation_failed", Field("errors", err))
+		}
+		return nil
+	})
+	server.Get("/unknown", func(ctx *Context) {
+		err := ctx.Problem("no_such_code")
+		assert.True(t, errors.Is(err, ErrUnknownProblem))
+	})
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header map[string]string
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "zh",
+			path:     "/users/12",
+			header:   map[string]string{"X-Request-Id": "req-1"},
+			wantCode: http.StatusNotFound,
+			wantBody: `{"type":"https://example.com/problems/user-not-found","title":"User not found","status":404,"detail":"用户 12 不存在","instance":"/users/12","code":"user_not_found","id":"12","request_id":"req-1"}`,
+		},
+		{
+			name:     "en",
+			path:     "/users/12",
+			header:   map[string]string{"Accept-Language": "en-US,en;q=0.9"},
+			wantCode: http.StatusNotFound,
+			wantBody: `{"type":"https://example.com/problems/user-not-found","title":"User not found","status":404,"detail":"User 12 does not exist","instance":"/users/12","code":"user_not_found","id":"12"}`,
+		},
+		{
+			name:     "validation",
+			method:   http.MethodPost,
+			path:     "/users",
+			body:     `{}`,
+			header:   map[string]string{"Content-Type": "application/json", "Accept-Language": "en"},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"The request failed validation","instance":"/users","code":"validation_failed","errors":[{"field":"name","tag":"required","message":"name is required"}]}`,
+		},
+		{
+			name:     "unknown code",
+			path:     "/unknown",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"type":"about:blank","title":"Internal Server Error","status":500,"instance":"/unknown"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.path, strings.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, "application/problem+json; charset=utf-8", recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestContext_ProblemTraceID(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+	req := httptest.NewRequest(http.MethodGet, "/users/12", nil)
+	req = req.WithContext(trace.ContextWithSpanContext(context.Background(), sc))
+	ctx := &Context{Req: req}
+	assert.NoError(t, ctx.Problem("not_found"))
+	var p Problem
+	assert.NoError(t, p.UnmarshalJSON(ctx.RespData))
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, "资源不存在", p.Detail)
+	assert.Equal(t, `"4bf92f3577b34da6a3ce929d0e0e4736"`, string(p.Extensions["trace_id"].(json.RawMessage)))
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	server := NewHTTPServer(ServerWithErrorHandler(ProblemErrorHandler))
+	server.RegisterProblem(ProblemType{Code: "user_not_found", Status: http.StatusNotFound})
+	server.GetE("/registered", func(ctx *Context) error {
+		return NewHTTPError(http.StatusGone, "user_not_found", "")
+	})
+	server.GetE("/unregistered", func(ctx *Context) error {
+		return NewHTTPError(http.StatusConflict, "order_paid", "订单已经支付")
+	})
+	server.GetE("/override", func(ctx *Context) error {
+		return &HTTPError{Status: http.StatusConflict, Code: "user_not_found", Message: "用户 1 冲突"}
+	})
+	server.GetE("/no-status", func(ctx *Context) error {
+		return &HTTPError{Code: "quota_exceeded", Message: "额度用完了"}
+	})
+	server.GetE("/bind", func(ctx *Context) error {
+		return ctx.Bind(&struct {
+			Age int `query:"age"`
+		}{})
+	})
+	server.GetE("/internal", func(ctx *Context) error {
+		return errors.New("数据库密码错误")
+	})
+
+	testCases := []struct {
+		name string
+		path string
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "registered",
+			path:     "/registered",
+			wantCode: http.StatusGone,
+			wantBody: `{"type":"about:blank","title":"Gone","status":410,"instance":"/registered","code":"user_not_found"}`,
+		},
+		{
+			name:     "registered with message",
+			path:     "/override",
+			wantCode: http.StatusConflict,
+			wantBody: `{"type":"about:blank","title":"Conflict","status":409,"detail":"用户 1 冲突","instance":"/override","code":"user_not_found"}`,
+		},
+		{
+			name:     "no status",
+			path:     "/no-status",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"额度用完了","instance":"/no-status","code":"quota_exceeded"}`,
+		},
+		{
+			name:     "unregistered",
+			path:     "/unregistered",
+			wantCode: http.StatusConflict,
+			wantBody: `{"type":"about:blank","title":"Conflict","status":409,"detail":"订单已经支付","instance":"/unregistered","code":"order_paid"}`,
+		},
+		{
+			name:     "bind",
+			path:     "/bind?age=abc",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"请求参数不合法","instance":"/bind","code":"bad_request","errors":[{"field":"Age","source":"query","message":"web: 字段 Age 绑定失败, query:\"age\" 的值 \"abc\" 不合法: strconv.ParseInt: parsing \"abc\": invalid syntax"}]}`,
+		},
+		{
+			name:     "internal",
+			path:     "/internal",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"服务器内部错误","instance":"/internal","code":"internal"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestProblemRegistry_Register(t *testing.T) {
+	r := NewProblemRegistry()
+	assert.Panics(t, func() {
+		r.Register(ProblemType{Status: http.StatusBadRequest})
+	})
+	assert.Panics(t, func() {
+		r.Register(ProblemType{Code: "ok", Status: http.StatusOK})
+	})
+	_, err := r.New("no_such_code", "zh")
+	assert.True(t, errors.Is(err, ErrUnknownProblem))
+}
+
+func TestContext_PreferredLanguage(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "empty"},
+		{name: "first", header: "en-US,en;q=0.9", want: "en-US"},
+		{name: "q value", header: "en;q=0.5, zh-CN;q=0.8, fr;q=0.8", want: "zh-CN"},
+		{name: "wildcard", header: "*, en;q=0.5", want: "en"},
+		{name: "q zero", header: "zh;q=0", want: ""},
+		{name: "invalid q", header: "zh;q=abc, en;q=0.1", want: "en"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Language", tc.header)
+			ctx := &Context{Req: req}
+			assert.Equal(t, tc.want, ctx.PreferredLanguage())
+		})
+	}
+}
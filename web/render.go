@@ -0,0 +1,330 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotAcceptable 没有任何一种媒体类型能满足 Accept
+// 对应的是 406 响应
+var ErrNotAcceptable = errors.New("web: 没有可以接受的响应格式")
+
+// errUnsupportedValue Codec 不能编码这种类型的值，比如说结构体不能渲染为纯文本
+// Render 遇到它会尝试下一种格式，都不行的话就是 406
+var errUnsupportedValue = errors.New("web: 不支持编码")
+
+// renderMediaTypes Render 支持的媒体类型，按照优先级排列
+// 客户端没有指定 Accept，或者 Accept 是 */* 的时候，用第一个能编码的
+var renderMediaTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/yaml",
+	"application/x-protobuf",
+	"text/plain",
+	"text/html",
+}
+
+// encodeOnlyCodecs 只在 Render 的时候使用，不会用来解析请求体
+// 不然带着 text/plain 请求体的 Bind 就会失败。
+// 需要解析纯文本请求体的，可以用 ServerWithCodec("text/plain", TextCodec{}) 注册
+var encodeOnlyCodecs = map[string]Codec{
+	"text/plain": TextCodec{},
+	"text/html":  HTMLCodec{},
+}
+
+// Render 根据 Accept 选择响应的格式，然后用对应的 Codec 编码 val
+// 会设置好 Content-Type 和 Content-Length
+// 没有可以接受的格式的时候，响应 406 并且返回 ErrNotAcceptable
+// val 是 View 的时候，用模板引擎渲染为 HTML
+func (c *Context) Render(status int, val any) error {
+	if view, ok := val.(View); ok {
+		return c.renderView(status, view)
+	}
+	var encodeErr error
+	for _, mediaType := range c.renderOrder() {
+		codec, ok := c.codec(mediaType)
+		if !ok {
+			codec, ok = encodeOnlyCodecs[mediaType]
+		}
+		if !ok {
+			continue
+		}
+		data, err := codec.Encode(val)
+		if err != nil {
+			// 比如说 val 不是 proto.Message，那么就试试下一种
+			// 真正的编码错误，比如说 JSON 里面有 chan，要返回给调用者
+			if encodeErr == nil && !errors.Is(err, errUnsupportedValue) {
+				encodeErr = err
+			}
+			continue
+		}
+		c.RespHeader().Add("Vary", "Accept")
+		c.Data(status, contentType(mediaType), data)
+		return nil
+	}
+	if encodeErr != nil {
+		return encodeErr
+	}
+	c.RespHeader().Add("Vary", "Accept")
+	c.RespStatusCode = http.StatusNotAcceptable
+	c.RespData = nil
+	return ErrNotAcceptable
+}
+
+// renderOrder Render 尝试的顺序
+// 先是客户端最想要的，也就是 q 值最高的那些，剩下可以接受的按照 renderMediaTypes 的顺序。
+// 浏览器的 Accept 一般是 text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8，
+// 结构体渲染不了 HTML 的时候，用 JSON 而不是 XML
+func (c *Context) renderOrder() []string {
+	ranges := parseAccept(c.Req.Header.Get("Accept"))
+	var top []acceptRange
+	for _, r := range ranges {
+		if r.q == 0 {
+			continue
+		}
+		if len(top) > 0 && r.q < top[0].q {
+			break
+		}
+		top = append(top, r)
+	}
+	res := acceptable(ranges, top, renderMediaTypes)
+	used := make(map[string]bool, len(renderMediaTypes))
+	for _, mediaType := range res {
+		used[mediaType] = true
+	}
+	rest := make(map[string]bool, len(renderMediaTypes))
+	for _, mediaType := range acceptable(ranges, ranges, renderMediaTypes) {
+		rest[mediaType] = !used[mediaType]
+	}
+	for _, mediaType := range renderMediaTypes {
+		if rest[mediaType] {
+			res = append(res, mediaType)
+		}
+	}
+	return res
+}
+
+// Negotiate 从 offers 里面选出最符合 Accept 的媒体类型
+// 都不能接受的话返回空字符串
+func (c *Context) Negotiate(offers ...string) string {
+	res := c.acceptable(offers)
+	if len(res) == 0 {
+		return ""
+	}
+	return res[0]
+}
+
+// acceptable 按照 Accept 的优先级返回 offers 里面可以接受的媒体类型
+func (c *Context) acceptable(offers []string) []string {
+	ranges := parseAccept(c.Req.Header.Get("Accept"))
+	return acceptable(ranges, ranges, offers)
+}
+
+// acceptable 按照 candidates 的顺序返回 offers 里面可以接受的媒体类型
+// ranges 是完整的 Accept，用来判断 q=0 的排除规则
+func acceptable(ranges []acceptRange, candidates []acceptRange, offers []string) []string {
+	res := make([]string, 0, len(offers))
+	used := make(map[string]bool, len(offers))
+	for _, r := range candidates {
+		if r.q == 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if used[offer] || !r.match(offer) || excluded(ranges, r, offer) {
+				continue
+			}
+			used[offer] = true
+			res = append(res, offer)
+		}
+	}
+	return res
+}
+
+// excluded q=0 代表明确不接受
+// 比如说 "*/*, text/*;q=0" 就是除了 text 之外都可以
+func excluded(ranges []acceptRange, matched acceptRange, offer string) bool {
+	for _, r := range ranges {
+		if r.q == 0 && r.specificity >= matched.specificity && r.match(offer) {
+			return true
+		}
+	}
+	return false
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	// specificity 越具体的优先级越高，比如说 text/html > text/* > */*
+	specificity int
+}
+
+func (r acceptRange) match(mediaType string) bool {
+	if r.mediaType == "*/*" || r.mediaType == mediaType {
+		return true
+	}
+	typ, sub, _ := strings.Cut(r.mediaType, "/")
+	offerType, _, _ := strings.Cut(mediaType, "/")
+	return sub == "*" && typ == offerType
+}
+
+// parseAccept 解析 Accept 头部，按照 q 值和具体程度排序
+// 没有 Accept 的时候等价于 */*
+func parseAccept(header string) []acceptRange {
+	if strings.TrimSpace(header) == "" {
+		return []acceptRange{{mediaType: "*/*", q: 1}}
+	}
+	parts := strings.Split(header, ",")
+	res := make([]acceptRange, 0, len(parts))
+	for _, part := range parts {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		r := acceptRange{mediaType: mediaType, q: 1}
+		if q, ok := params["q"]; ok {
+			if r.q, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "*/*":
+			r.specificity = 0
+		case strings.HasSuffix(mediaType, "/*"):
+			r.specificity = 1
+		default:
+			r.specificity = 2
+		}
+		res = append(res, r)
+	}
+	// 稳定排序，q 值一样的保持客户端给出的顺序
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].q != res[j].q {
+			return res[i].q > res[j].q
+		}
+		return res[i].specificity > res[j].specificity
+	})
+	return res
+}
+
+// contentType 文本类型的都加上 charset
+func contentType(mediaType string) string {
+	if strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		mediaType == "application/yaml" || strings.HasSuffix(mediaType, "+json") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// String 响应纯文本
+func (c *Context) String(status int, val string) {
+	c.Data(status, "text/plain; charset=utf-8", []byte(val))
+}
+
+// HTML 响应 HTML，html 会被原样输出，所以不要把用户的输入直接拼接进来
+func (c *Context) HTML(status int, html string) {
+	c.Data(status, "text/html; charset=utf-8", []byte(html))
+}
+
+// Data 响应任意数据
+func (c *Context) Data(status int, contentType string, data []byte) {
+	header := c.RespHeader()
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	c.RespStatusCode = status
+	c.RespData = data
+}
+
+// Attachment 让浏览器把 data 作为文件下载，filename 是下载之后的文件名
+func (c *Context) Attachment(filename string, data []byte) {
+	ct := mime.TypeByExtension(filepath.Ext(filename))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	// 中文之类的文件名，FormatMediaType 会自动使用 filename* 的形式
+	c.RespHeader().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, ct, data)
+}
+
+// NoContent 响应 204
+func (c *Context) NoContent() {
+	c.RespStatusCode = http.StatusNoContent
+	c.RespData = nil
+	c.RespHeader().Del("Content-Length")
+}
+
+// YAMLCodec 使用 gopkg.in/yaml.v3
+type YAMLCodec struct{}
+
+func (YAMLCodec) Decode(req *http.Request, val any) error {
+	return yaml.NewDecoder(req.Body).Decode(val)
+}
+
+func (YAMLCodec) Encode(val any) ([]byte, error) {
+	return yaml.Marshal(val)
+}
+
+// TextCodec 纯文本
+type TextCodec struct{}
+
+// Decode val 只能是 *string 或者 *[]byte
+func (TextCodec) Decode(req *http.Request, val any) error {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	switch v := val.(type) {
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = data
+	default:
+		return fmt.Errorf("web: 纯文本只能解析到 *string 或者 *[]byte，但是输入是 %T", val)
+	}
+	return nil
+}
+
+// Encode val 只能是 string, []byte 或者 fmt.Stringer
+// 没有实现 fmt.Stringer 的 error 不会被编码，因为错误信息可能包含内部的细节
+func (TextCodec) Encode(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	return nil, fmt.Errorf("%w: 不能把 %T 渲染为纯文本", errUnsupportedValue, val)
+}
+
+// HTMLCodec 只能编码字符串和 template.HTML
+// 字符串会被转义，template.HTML 则是原样输出
+type HTMLCodec struct{}
+
+func (HTMLCodec) Decode(req *http.Request, val any) error {
+	return TextCodec{}.Decode(req, val)
+}
+
+func (HTMLCodec) Encode(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case template.HTML:
+		return []byte(v), nil
+	case string:
+		return []byte(html.EscapeString(v)), nil
+	}
+	return nil, fmt.Errorf("%w: 不能把 %T 渲染为 HTML", errUnsupportedValue, val)
+}
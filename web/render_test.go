@@ -0,0 +1,253 @@
+package web
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type renderUser struct {
+	Name string `json:"name" xml:"name" yaml:"name"`
+}
+
+func TestContext_Render(t *testing.T) {
+	pbData, err := proto.Marshal(wrapperspb.String("Tom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name   string
+		accept string
+		val    any
+
+		wantCode        int
+		wantContentType string
+		wantBody        string
+		wantErr         error
+	}{
+		{
+			name:            "no accept",
+			val:             renderUser{Name: "Tom"},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"name":"Tom"}`,
+		},
+		{
+			name:            "xml",
+			accept:          "application/xml",
+			val:             renderUser{Name: "Tom"},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/xml",
+			wantBody:        "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<renderUser><name>Tom</name></renderUser>",
+		},
+		{
+			name:            "q value",
+			accept:          "application/json;q=0.5, application/yaml;q=0.8, */*;q=0.1",
+			val:             renderUser{Name: "Tom"},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/yaml; charset=utf-8",
+			wantBody:        "name: Tom\n",
+		},
+		{
+			name:            "specificity",
+			accept:          "text/*, text/html",
+			val:             "<b>Tom</b>",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "&lt;b&gt;Tom&lt;/b&gt;",
+		},
+		{
+			name:            "trusted html",
+			accept:          "text/html",
+			val:             template.HTML("<b>Tom</b>"),
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<b>Tom</b>",
+		},
+		{
+			// 结构体不能渲染为 HTML，退化为 JSON
+			name:            "fallback",
+			accept:          "text/html, application/json;q=0.5",
+			val:             renderUser{Name: "Tom"},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"name":"Tom"}`,
+		},
+		{
+			// 结构体不能渲染为纯文本
+			name:     "text only",
+			accept:   "text/plain",
+			val:      renderUser{Name: "Tom"},
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+		{
+			// error 可能包含内部的细节
+			name:     "text error",
+			accept:   "text/plain",
+			val:      errors.New("数据库密码错误"),
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+		{
+			// 浏览器的 Accept，结构体渲染不了 HTML，用 JSON 而不是 XML
+			name:            "browser",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			val:             renderUser{Name: "Tom"},
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"name":"Tom"}`,
+		},
+		{
+			name:            "browser html",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			val:             template.HTML("<b>Tom</b>"),
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<b>Tom</b>",
+		},
+		{
+			name:            "protobuf",
+			accept:          "application/x-protobuf",
+			val:             wrapperspb.String("Tom"),
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-protobuf",
+			wantBody:        string(pbData),
+		},
+		{
+			name:     "not acceptable",
+			accept:   "image/png",
+			val:      renderUser{Name: "Tom"},
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+		{
+			name:     "excluded",
+			accept:   "*/*, application/*;q=0, text/*;q=0",
+			val:      renderUser{Name: "Tom"},
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewHTTPServer()
+			var renderErr error
+			server.Get("/user", func(ctx *Context) {
+				renderErr = ctx.Render(http.StatusOK, tc.val)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			req.Header.Set("Accept", tc.accept)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.True(t, errors.Is(renderErr, tc.wantErr))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, "Accept", recorder.Header().Get("Vary"))
+			if tc.wantErr != nil {
+				return
+			}
+			assert.Equal(t, tc.wantContentType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestContext_Negotiate(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	ctx := &Context{Req: req}
+	assert.Equal(t, "text/html", ctx.Negotiate("application/json", "text/html"))
+	assert.Equal(t, "application/json", ctx.Negotiate("application/json"))
+	req.Header.Set("Accept", "application/json")
+	assert.Equal(t, "", ctx.Negotiate("text/html"))
+}
+
+func TestContext_helpers(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler HandleFunc
+
+		wantCode   int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			name: "string",
+			handler: func(ctx *Context) {
+				ctx.String(http.StatusOK, "hello")
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"text/plain; charset=utf-8"},
+				"Content-Length": []string{"5"},
+			},
+			wantBody: "hello",
+		},
+		{
+			name: "html",
+			handler: func(ctx *Context) {
+				ctx.HTML(http.StatusBadRequest, "<h1>请求不对</h1>")
+			},
+			wantCode: http.StatusBadRequest,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"text/html; charset=utf-8"},
+				"Content-Length": []string{"21"},
+			},
+			wantBody: "<h1>请求不对</h1>",
+		},
+		{
+			name: "json",
+			handler: func(ctx *Context) {
+				_ = ctx.RespJSON(http.StatusAccepted, renderUser{Name: "Tom"})
+			},
+			wantCode: http.StatusAccepted,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"application/json; charset=utf-8"},
+				"Content-Length": []string{"14"},
+			},
+			wantBody: `{"name":"Tom"}`,
+		},
+		{
+			name: "attachment",
+			handler: func(ctx *Context) {
+				ctx.Attachment("订单.csv", []byte("id\n1"))
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":        []string{"text/csv; charset=utf-8"},
+				"Content-Length":      []string{"4"},
+				"Content-Disposition": []string{"attachment; filename*=utf-8''%E8%AE%A2%E5%8D%95.csv"},
+			},
+			wantBody: "id\n1",
+		},
+		{
+			name: "no content",
+			handler: func(ctx *Context) {
+				ctx.String(http.StatusOK, "hello")
+				ctx.NoContent()
+			},
+			wantCode: http.StatusNoContent,
+			wantHeader: http.Header{
+				"Content-Type": []string{"text/plain; charset=utf-8"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewHTTPServer()
+			server.Get("/user", tc.handler)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantHeader, recorder.Result().Header)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
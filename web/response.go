@@ -0,0 +1,278 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+var errRespCommitted = errors.New("web: 响应已经发送，RespData 被丢弃")
+
+// RespMode 响应的写入模式
+type RespMode uint8
+
+const (
+	// RespModeBuffered 缓冲模式，也是默认的模式
+	// 无论是设置 RespData 和 RespStatusCode，还是直接写 Resp，
+	// 数据都先缓存在 Context 里面，最后由 flashResp 一次性发送出去。
+	// 在此之前，middleware 都可以修改响应
+	RespModeBuffered RespMode = iota
+	// RespModeStreaming 流式模式
+	// 第一次写入的时候就会把响应头和已经缓存的 RespData 发送出去，
+	// 后面的写入直接发送到底层连接
+	RespModeStreaming
+	// RespModeHijacked 连接已经被用户接管了，比如说 websocket
+	// 框架不会再写入任何数据
+	RespModeHijacked
+)
+
+// responseWriter 是 Context.Resp 的实际类型
+// 用户直接使用 Resp 的时候，我们依旧能记录下响应码、响应头和写入的数据，
+// 这样 accesslog，prometheus 之类的 middleware 才能正确工作
+type responseWriter struct {
+	ctx *Context
+	// 原生的 ResponseWriter
+	w http.ResponseWriter
+	// 在发送之前缓存的响应头
+	header http.Header
+
+	mode RespMode
+	// 响应头是否已经发送
+	committed bool
+	// 已经写入到底层连接的字节数
+	size int
+	// 发送响应头之前执行的回调
+	beforeCommit []func()
+}
+
+func newResponseWriter(ctx *Context, w http.ResponseWriter) *responseWriter {
+	return &responseWriter{
+		ctx:    ctx,
+		w:      w,
+		header: make(http.Header),
+	}
+}
+
+// Header 在响应头发送之前，返回的是缓存的响应头
+// 发送之后，修改响应头已经没有意义了，和 net/http 的行为保持一致
+func (w *responseWriter) Header() http.Header {
+	if w.committed {
+		return w.w.Header()
+	}
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.committed {
+		// 和 net/http 一样，重复调用没有任何效果
+		return
+	}
+	w.ctx.RespStatusCode = statusCode
+	if w.mode == RespModeStreaming {
+		w.commit()
+	}
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.mode == RespModeHijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.mode == RespModeBuffered {
+		// 和 net/http 一样，没有调用 WriteHeader 就相当于 200
+		if w.ctx.RespStatusCode == 0 {
+			w.ctx.RespStatusCode = http.StatusOK
+		}
+		w.ctx.RespData = append(w.ctx.RespData, data...)
+		return len(data), nil
+	}
+	if !w.committed {
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.w.Write(data)
+	w.size += n
+	return n, err
+}
+
+// commit 发送响应头，以及还缓存着的 RespData
+func (w *responseWriter) commit() error {
+	w.runBeforeCommit()
+	w.committed = true
+	dst := w.w.Header()
+	for key, vals := range w.header {
+		dst[key] = vals
+	}
+	if w.ctx.RespStatusCode == 0 {
+		w.ctx.RespStatusCode = http.StatusOK
+	}
+	w.w.WriteHeader(w.ctx.RespStatusCode)
+	if len(w.ctx.RespData) == 0 {
+		return nil
+	}
+	// 已经发送出去的数据就不能再留在 RespData 里面了，
+	// 不然 flashResp 会再写一遍
+	data := w.ctx.RespData
+	w.ctx.RespData = nil
+	n, err := w.w.Write(data)
+	w.size += n
+	return err
+}
+
+// flush 把缓存的响应发送出去，只在处理完请求之后调用
+// 返回值是写入失败的原因
+func (w *responseWriter) flush() error {
+	if w.mode == RespModeHijacked {
+		return nil
+	}
+	if w.committed {
+		// 流式模式下已经发送过了
+		if len(w.ctx.RespData) > 0 {
+			return errRespCommitted
+		}
+		return nil
+	}
+	// 回调可能会设置响应头，所以要在判断之前执行
+	w.runBeforeCommit()
+	if w.ctx.RespStatusCode == 0 && len(w.ctx.RespData) == 0 && len(w.header) == 0 {
+		// 什么都没有，交给 net/http 去处理
+		return nil
+	}
+	// 缓冲模式下我们知道完整的响应，所以可以设置准确的 Content-Length
+	// 即便 middleware 篡改了 RespData 也不会出错
+	if bodyAllowed(w.ctx.RespStatusCode) && w.header.Get("Transfer-Encoding") == "" {
+		w.header.Set("Content-Length", strconv.Itoa(len(w.ctx.RespData)))
+	}
+	return w.commit()
+}
+
+// runBeforeCommit 执行 BeforeCommit 注册的回调，只会执行一次
+func (w *responseWriter) runBeforeCommit() {
+	fns := w.beforeCommit
+	w.beforeCommit = nil
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+// bodyAllowed 1xx, 204 和 304 是不能有响应体的
+func bodyAllowed(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// Flush 意味着用户希望数据立刻发送出去，所以会切换到流式模式
+func (w *responseWriter) Flush() {
+	if w.mode == RespModeHijacked {
+		return
+	}
+	w.mode = RespModeStreaming
+	if !w.committed {
+		_ = w.commit()
+	}
+	w.w.(http.Flusher).Flush()
+}
+
+// Hijack 之后连接就完全交给用户了，flashResp 不会再写入任何数据
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.w.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.mode = RespModeHijacked
+	w.committed = true
+	w.ctx.RespData = nil
+	return conn, rw, nil
+}
+
+// ReadFrom 一般用于发送文件，底层可能会用 sendfile 实现零拷贝
+// 这种场景下缓存整个文件是没有意义的，所以会切换到流式模式
+func (w *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.mode == RespModeHijacked {
+		return 0, http.ErrHijacked
+	}
+	w.mode = RespModeStreaming
+	if !w.committed {
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.w.(io.ReaderFrom).ReadFrom(src)
+	w.size += int(n)
+	return n, err
+}
+
+// Unwrap 返回原生的 ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+// basicWriter 是所有包装结果都会有的方法
+// 这里要用接口，不然 responseWriter 的所有方法都会被暴露出去
+type basicWriter interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// wrap 返回一个 ResponseWriter，它恰好实现了原生 ResponseWriter 所实现的
+// http.Flusher, http.Hijacker 和 io.ReaderFrom 接口
+// 用户依旧可以通过类型断言来判断能不能 Flush 或者 Hijack
+func (w *responseWriter) wrap() http.ResponseWriter {
+	_, isFlusher := w.w.(http.Flusher)
+	_, isHijacker := w.w.(http.Hijacker)
+	_, isReaderFrom := w.w.(io.ReaderFrom)
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			basicWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case isFlusher && isHijacker:
+		return struct {
+			basicWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w}
+	case isFlusher && isReaderFrom:
+		return struct {
+			basicWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case isHijacker && isReaderFrom:
+		return struct {
+			basicWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case isFlusher:
+		return struct {
+			basicWriter
+			http.Flusher
+		}{w, w}
+	case isHijacker:
+		return struct {
+			basicWriter
+			http.Hijacker
+		}{w, w}
+	case isReaderFrom:
+		return struct {
+			basicWriter
+			io.ReaderFrom
+		}{w, w}
+	default:
+		return struct {
+			basicWriter
+		}{w}
+	}
+}
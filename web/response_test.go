@@ -0,0 +1,186 @@
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type flushWriter struct {
+	http.ResponseWriter
+}
+
+func (f flushWriter) Flush() {}
+
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (h hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+type readerFromWriter struct {
+	http.ResponseWriter
+}
+
+func (r readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	return 0, nil
+}
+
+type fullWriter struct {
+	flushWriter
+	hijackWriter
+	readerFromWriter
+}
+
+func (f fullWriter) Header() http.Header {
+	return f.flushWriter.Header()
+}
+
+func (f fullWriter) Write(data []byte) (int, error) {
+	return f.flushWriter.Write(data)
+}
+
+func (f fullWriter) WriteHeader(statusCode int) {
+	f.flushWriter.WriteHeader(statusCode)
+}
+
+func TestResponseWriter_wrap(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testCases := []struct {
+		name string
+		w    http.ResponseWriter
+
+		wantFlusher    bool
+		wantHijacker   bool
+		wantReaderFrom bool
+	}{
+		{
+			name: "none",
+			w:    struct{ http.ResponseWriter }{recorder},
+		},
+		{
+			name:        "flusher",
+			w:           flushWriter{recorder},
+			wantFlusher: true,
+		},
+		{
+			name:         "hijacker",
+			w:            hijackWriter{recorder},
+			wantHijacker: true,
+		},
+		{
+			name:           "reader from",
+			w:              readerFromWriter{recorder},
+			wantReaderFrom: true,
+		},
+		{
+			name: "all",
+			w: fullWriter{
+				flushWriter:      flushWriter{recorder},
+				hijackWriter:     hijackWriter{recorder},
+				readerFromWriter: readerFromWriter{recorder},
+			},
+			wantFlusher:    true,
+			wantHijacker:   true,
+			wantReaderFrom: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newResponseWriter(&Context{}, tc.w).wrap()
+			_, ok := w.(http.Flusher)
+			assert.Equal(t, tc.wantFlusher, ok)
+			_, ok = w.(http.Hijacker)
+			assert.Equal(t, tc.wantHijacker, ok)
+			_, ok = w.(io.ReaderFrom)
+			assert.Equal(t, tc.wantReaderFrom, ok)
+			assert.Equal(t, tc.w, w.(interface{ Unwrap() http.ResponseWriter }).Unwrap())
+		})
+	}
+}
+
+func TestResponseWriter_optional(t *testing.T) {
+	// hijack 之后客户端可能在 handler 返回之前就拿到了响应
+	modes := make(chan RespMode, 1)
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			modes <- ctx.RespMode()
+		}
+	}))
+	server.Get("/flush", func(ctx *Context) {
+		ctx.RespData = []byte("hello, ")
+		ctx.Resp.(http.Flusher).Flush()
+		// 已经切换到了流式模式，直接写出去
+		_, _ = ctx.Resp.Write([]byte("world"))
+	})
+	server.Get("/hijack", func(ctx *Context) {
+		ctx.RespData = []byte("这些数据不会被发送")
+		conn, rw, err := ctx.Resp.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\nhijack")
+		_ = rw.Flush()
+		// 连接已经被接管了
+		_, err = ctx.Resp.Write([]byte("abc"))
+		assert.Equal(t, http.ErrHijacked, err)
+	})
+	server.Get("/file", func(ctx *Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		// 去掉 WriteTo，这样 io.Copy 才会用 ReadFrom
+		_, err := io.Copy(ctx.Resp, struct{ io.Reader }{strings.NewReader("file content")})
+		assert.NoError(t, err)
+	})
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	testCases := []struct {
+		path     string
+		wantBody string
+		wantMode RespMode
+	}{
+		{
+			path:     "/flush",
+			wantBody: "hello, world",
+			wantMode: RespModeStreaming,
+		},
+		{
+			path:     "/hijack",
+			wantBody: "hijack",
+			wantMode: RespModeHijacked,
+		},
+		{
+			path:     "/file",
+			wantBody: "file content",
+			wantMode: RespModeStreaming,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			resp, err := http.Get(s.URL + tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.wantBody, string(body))
+			assert.Equal(t, tc.wantMode, <-modes)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -23,7 +24,9 @@ func newRouter() router {
 
 // 加一些限制：
 // path 必须以 / 开头，不能以 / 结尾，中间也不能有连续的 //
-func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
+// mdls 是只作用在这个路由上的 middleware，在 HTTPServer 的 middleware 之后执行
+// 返回值是注册的节点
+func (r *router) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) *node {
 	if path == "" {
 		panic("web: 路径不能为空字符串")
 	}
@@ -58,16 +61,20 @@ func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
 		}
 		root.handler = handleFunc
 		root.route = "/"
-		return
+		root.mdls = mdls
+		return root
 	}
 
 	// /user/home 被切割成三段
 	// 切割这个 path
 	segs := strings.Split(path[1:], "/")
-	for _, seg := range segs {
+	for i, seg := range segs {
 		if seg == "" {
 			panic("web: 不能有连续的 /")
 		}
+		if len(seg) > 1 && seg[0] == '*' && i != len(segs)-1 {
+			panic(fmt.Sprintf("web: 通配符 %s 只能是路由的最后一段", seg))
+		}
 		// 递归下去，找准位置
 		// 如果中途有节点不存在，你就要创建出来
 		child := root.childOrCreate(seg)
@@ -78,6 +85,21 @@ func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
 	}
 	root.handler = handleFunc
 	root.route = path
+	root.mdls = mdls
+	return root
+}
+
+// allowedMethods path 在哪些 HTTP 方法下面有 handler，按照字母序排列
+// 用来在方法不匹配的时候响应 405 和 Allow 头部
+func (r *router) allowedMethods(path string) []string {
+	var res []string
+	for method := range r.trees {
+		if info, ok := r.findRoute(method, path); ok && info.n.handler != nil {
+			res = append(res, method)
+		}
+	}
+	sort.Strings(res)
+	return res
 }
 
 func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
@@ -88,21 +110,50 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 	}
 
 	if path == "/" {
+		if root.handler == nil && root.catchAllChild != nil {
+			return root.catchAllChild.matchAll(nil, ""), true
+		}
 		return &matchInfo{
 			n: root,
 		}, true
 	}
 
+	// /static/ 这种以 / 结尾的，允许 *filepath 匹配空的一段
+	trailingSlash := strings.HasSuffix(path, "/")
 	// 这里把前置和后置的 / 都去掉
 	path = strings.Trim(path, "/")
 
 	// 按照斜杠切割
 	segs := strings.Split(path, "/")
 	var pathParams map[string]string
-	for _, seg := range segs {
+	// 一路上最后经过的 *filepath，后面匹配不上的时候退回到它
+	// 比如说同时注册了 /files/*path 和 /files/readme，/files/readme/x 还是由 /files/*path 处理
+	var catchAll *node
+	var catchAllFrom int
+	var catchAllParams map[string]string
+	fallback := func() (*matchInfo, bool) {
+		if catchAll == nil {
+			return nil, false
+		}
+		// *filepath 匹配剩下所有的段，比如说 /static/*filepath 可以匹配 /static/js/app.js
+		rest := strings.Join(segs[catchAllFrom:], "/")
+		if trailingSlash {
+			rest += "/"
+		}
+		return catchAll.matchAll(catchAllParams, rest), true
+	}
+	for i, seg := range segs {
+		if root.catchAllChild != nil {
+			catchAll, catchAllFrom = root.catchAllChild, i
+			// 后面命中的路径参数不属于这个路由
+			catchAllParams = make(map[string]string, len(pathParams)+1)
+			for key, val := range pathParams {
+				catchAllParams[key] = val
+			}
+		}
 		child, paramChild, found := root.childOf(seg)
 		if !found {
-			return nil, false
+			return fallback()
 		}
 		// 命中了路径参数
 		if paramChild {
@@ -114,6 +165,12 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 		}
 		root = child
 	}
+	if trailingSlash && root.handler == nil && root.catchAllChild != nil {
+		return root.catchAllChild.matchAll(pathParams, ""), true
+	}
+	if root.handler == nil && catchAll != nil {
+		return fallback()
+	}
 	// 代表我确实有这个节点
 	// 但是节点是不是用户注册的有 handler 的，就不一定了
 	return &matchInfo{
@@ -127,11 +184,17 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 func (n *node) childOrCreate(seg string) *node {
 
 	if seg[0] == ':' {
-		if n.starChild != nil {
+		if n.starChild != nil || n.catchAllChild != nil {
 			panic("web: 不允许同时注册路径参数和通配符匹配，已有通配符匹配")
 		}
-		n.paramChild = &node{
-			path: seg,
+		// 已经有了就复用，不然 /user/:id 之后注册 /user/:id/detail 会把前一个覆盖掉
+		if n.paramChild != nil && n.paramChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突，路径参数 %s 和 %s 冲突", seg, n.paramChild.path))
+		}
+		if n.paramChild == nil {
+			n.paramChild = &node{
+				path: seg,
+			}
 		}
 		return n.paramChild
 	}
@@ -140,12 +203,33 @@ func (n *node) childOrCreate(seg string) *node {
 		if n.paramChild != nil {
 			panic("web: 不允许同时注册路径参数和通配符匹配，已有路径参数")
 		}
-		n.starChild = &node{
-			path: seg,
+		if n.catchAllChild != nil {
+			panic("web: 不允许同时注册 * 和 " + n.catchAllChild.path)
+		}
+		if n.starChild == nil {
+			n.starChild = &node{
+				path: seg,
+			}
 		}
 		return n.starChild
 	}
 
+	// *filepath 这种，匹配剩下所有的段
+	if seg[0] == '*' {
+		if n.paramChild != nil || n.starChild != nil {
+			panic("web: 不允许同时注册 " + seg + " 和路径参数或者通配符匹配")
+		}
+		if n.catchAllChild != nil && n.catchAllChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突，%s 和 %s", seg, n.catchAllChild.path))
+		}
+		if n.catchAllChild == nil {
+			n.catchAllChild = &node{
+				path: seg,
+			}
+		}
+		return n.catchAllChild
+	}
+
 	if n.children == nil {
 		n.children = map[string]*node{}
 	}
@@ -181,6 +265,18 @@ func (n *node) childOf(path string) (*node, bool, bool) {
 	return child, false, ok
 }
 
+// matchAll 命中了 *filepath，剩下的路径作为路径参数 filepath 的值
+func (n *node) matchAll(pathParams map[string]string, rest string) *matchInfo {
+	if pathParams == nil {
+		pathParams = make(map[string]string, 1)
+	}
+	pathParams[n.path[1:]] = rest
+	return &matchInfo{
+		n:          n,
+		pathParams: pathParams,
+	}
+}
+
 // type tree struct {
 // 	root *node
 // }
@@ -200,8 +296,16 @@ type node struct {
 	// 加一个路径参数
 	paramChild *node
 
+	// *filepath 这种匹配剩下所有段的通配符，只能在最后
+	catchAllChild *node
+
 	// 缺一个代表用户注册的业务逻辑
 	handler HandleFunc
+
+	// 路由级别的 middleware
+	mdls []Middleware
+	// chain 组装好了路由级别的 middleware 的 handler，注册路由的时候就组装好
+	chain HandleFunc
 }
 
 type matchInfo struct {
@@ -172,6 +172,127 @@ func TestRouter_addRoute(t *testing.T) {
 	assert.Panicsf(t, func() {
 		r.addRoute(http.MethodGet, "/a/*", mockHandler)
 	}, "web: 不允许同时注册路径参数和通配符匹配，已有路径参数")
+
+	// 同一个位置的路径参数名字不一样，不然前面注册的路由就丢了
+	r = newRouter()
+	r.addRoute(http.MethodGet, "/user/:id", mockHandler)
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/user/:name/detail", mockHandler)
+	}, "web: 路由冲突，路径参数 :name 和 :id 冲突")
+	_, found := r.findRoute(http.MethodGet, "/user/1")
+	assert.True(t, found)
+}
+
+func TestRouter_catchAll(t *testing.T) {
+	r := newRouter()
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r.addRoute(http.MethodGet, "/*filepath", mockHandler)
+	r.addRoute(http.MethodGet, "/static/*filepath", mockHandler)
+	r.addRoute(http.MethodGet, "/static/favicon.ico", mockHandler)
+	// 后面注册的路由不会改变已有路由的语义
+	r.addRoute(http.MethodGet, "/order/*", mockHandler)
+	r.addRoute(http.MethodGet, "/order/*/detail", mockHandler)
+	r.addRoute(http.MethodGet, "/files/*path", mockHandler)
+	r.addRoute(http.MethodGet, "/files/readme", mockHandler)
+	r.addRoute(http.MethodGet, "/files/readme/:id/raw", mockHandler)
+
+	testCases := []struct {
+		path string
+
+		wantFound  bool
+		wantRoute  string
+		wantParams map[string]string
+	}{
+		{
+			path:       "/static/js/app.js",
+			wantFound:  true,
+			wantRoute:  "/static/*filepath",
+			wantParams: map[string]string{"filepath": "js/app.js"},
+		},
+		{
+			path:       "/static/docs/",
+			wantFound:  true,
+			wantRoute:  "/static/*filepath",
+			wantParams: map[string]string{"filepath": "docs/"},
+		},
+		{
+			path:       "/static/",
+			wantFound:  true,
+			wantRoute:  "/static/*filepath",
+			wantParams: map[string]string{"filepath": ""},
+		},
+		{
+			// 静态匹配优先
+			path:      "/static/favicon.ico",
+			wantFound: true,
+			wantRoute: "/static/favicon.ico",
+		},
+		{
+			path:       "/",
+			wantFound:  true,
+			wantRoute:  "/*filepath",
+			wantParams: map[string]string{"filepath": ""},
+		},
+		{
+			path:       "/a/b",
+			wantFound:  true,
+			wantRoute:  "/*filepath",
+			wantParams: map[string]string{"filepath": "a/b"},
+		},
+		{
+			path:      "/order/abc",
+			wantFound: true,
+			wantRoute: "/order/*",
+		},
+		{
+			// * 只匹配一段，剩下的退回到根节点的 /*filepath
+			path:       "/order/abc/def",
+			wantFound:  true,
+			wantRoute:  "/*filepath",
+			wantParams: map[string]string{"filepath": "order/abc/def"},
+		},
+		{
+			// 静态匹配走到头了没有 handler，退回到前面经过的 *filepath
+			path:       "/static/favicon.ico/x",
+			wantFound:  true,
+			wantRoute:  "/static/*filepath",
+			wantParams: map[string]string{"filepath": "favicon.ico/x"},
+		},
+		{
+			path:       "/files/readme/x",
+			wantFound:  true,
+			wantRoute:  "/files/*path",
+			wantParams: map[string]string{"path": "readme/x"},
+		},
+		{
+			// 退回的时候，后面命中的路径参数不算
+			path:       "/files/readme/1/detail",
+			wantFound:  true,
+			wantRoute:  "/files/*path",
+			wantParams: map[string]string{"path": "readme/1/detail"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, tc.wantFound, found)
+			if !found || !tc.wantFound {
+				return
+			}
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantParams, info.pathParams)
+		})
+	}
+
+	assert.Panics(t, func() {
+		r := newRouter()
+		r.addRoute(http.MethodGet, "/a/*filepath/b", mockHandler)
+	})
+	assert.Panics(t, func() {
+		r := newRouter()
+		r.addRoute(http.MethodGet, "/a/*filepath", mockHandler)
+		r.addRoute(http.MethodGet, "/a/:id", mockHandler)
+	})
 }
 
 // 返回一个错误信息，帮助我们排查问题
@@ -212,6 +333,13 @@ func (n *node) equal(y *node) (string, bool) {
 		}
 	}
 
+	if n.catchAllChild != nil {
+		msg, ok := n.catchAllChild.equal(y.catchAllChild)
+		if !ok {
+			return msg, ok
+		}
+	}
+
 	// 比较 handler
 	nHandler := reflect.ValueOf(n.handler)
 	yHandler := reflect.ValueOf(y.handler)
@@ -322,6 +450,12 @@ func TestRouter_findRoute(t *testing.T) {
 				},
 			},
 		},
+		{
+			// * 只能匹配一段，匹配多段要用 *filepath
+			name:   "order star multiple segments",
+			method: http.MethodGet,
+			path:   "/order/abc/def",
+		},
 		{
 			// 命中了，但是没有 handler
 			name:      "order",
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type HandleFunc func(ctx *Context)
@@ -20,7 +22,8 @@ type Server interface {
 	// method 是 HTTP 方法
 	// path 是路由
 	// handleFunc 是你的业务逻辑
-	addRoute(method string, path string, handleFunc HandleFunc)
+	// mdls 是路由级别的 middleware
+	addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware)
 	// 这种允许注册多个，没有必要提供
 	// 让用户自己去管
 	// AddRoute1(method string, path string, handles ...HandleFunc)
@@ -43,6 +46,26 @@ type HTTPServer struct {
 
 	log func(msg string, args...any)
 
+	validator *Validator
+
+	// 媒体类型 => Codec
+	codecs map[string]Codec
+
+	tplEngine TemplateEngine
+
+	wsUpgrader *WebSocketUpgrader
+
+	// 可信的代理
+	trustedProxies []*net.IPNet
+	// 可信的代理使用的头部
+	forwardedHeader ForwardedHeader
+
+	errHandler ErrorHandler
+
+	// 错误码目录
+	problems *ProblemRegistry
+
+	phaseHook PhaseHook
 }
 
 func NewHTTPServerV1(mdls ...Middleware) *HTTPServer {
@@ -64,6 +87,9 @@ func NewHTTPServer(opts ...HTTPServerOption) *HTTPServer {
 		log: func(msg string, args ...any) {
 			fmt.Printf(msg, args...)
 		},
+		validator: NewValidator(),
+		codecs:    newCodecs(),
+		problems:  NewProblemRegistry(),
 	}
 	for _, opt := range opts {
 		opt(res)
@@ -77,13 +103,31 @@ func ServerWithMiddleware(mdls ...Middleware) HTTPServerOption {
 	}
 }
 
+// ServerWithValidator 替换掉默认的 Validator
+func ServerWithValidator(v *Validator) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.validator = v
+	}
+}
+
+// RegisterValidation 注册自定义的校验规则，之后就可以在 validate 标签里面使用
+func (h *HTTPServer) RegisterValidation(name string, fn ValidateFunc) {
+	if h.validator == nil {
+		h.validator = NewValidator()
+	}
+	h.validator.RegisterRule(name, fn)
+}
+
 // ServeHTTP 处理请求的入口
 func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	// 你的框架代码就在这里
 	ctx := &Context{
-		Req:  request,
-		Resp: writer,
+		Req:       request,
+		server:    h,
+		startTime: time.Now(),
 	}
+	ctx.rw = newResponseWriter(ctx, writer)
+	ctx.Resp = ctx.rw.wrap()
 
 	// 最后一个是这个
 	root := h.serve
@@ -91,9 +135,8 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 	// 然后这里就是利用最后一个不断往前回溯组装链条
 	// 从后往前
 	// 把后一个作为前一个的 next 构造好链条
-	for i := len(h.mdls) - 1; i >= 0; i-- {
-		root = h.mdls[i](root)
-	}
+	// 有 PhaseHook 的时候，每一个 middleware 都会被记录为一个阶段
+	root = h.chain(h.mdls, root)
 
 	// 这里执行的时候，就是从前往后了
 
@@ -101,9 +144,10 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 
 	var m Middleware = func(next HandleFunc) HandleFunc {
 		return func(ctx *Context) {
+			defer ctx.runAfterFlush()
 			// 就设置好了 RespData 和 RespStatusCode
 			next(ctx)
-			h.flashResp(ctx)
+			ctx.phase(PhaseFlush, h.flashResp)
 		}
 	}
 	root = m(root)
@@ -112,20 +156,31 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 }
 
 func (h *HTTPServer) flashResp(ctx *Context) {
-	if ctx.RespStatusCode != 0 {
-		ctx.Resp.WriteHeader(ctx.RespStatusCode)
-	}
-	n, err := ctx.Resp.Write(ctx.RespData)
-	if err != nil || n != len(ctx.RespData) {
+	// 缓存的响应头，响应码和 RespData 都在这里一次性发送出去
+	// 流式模式下已经发送过的部分不会重复发送
+	if err := ctx.rw.flush(); err != nil {
 		h.log("写入响应失败 %v", err)
 	}
 }
 
 func (h *HTTPServer) serve(ctx *Context) {
 	// before route
-	info, ok := h.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
+	var info *matchInfo
+	var ok bool
+	ctx.phase(PhaseRoute, func(ctx *Context) {
+		stop := ctx.Timing(TimingRoute, "")
+		info, ok = h.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
+		stop()
+	})
 	// after route
 	if !ok || info.n.handler == nil {
+		// 别的方法能命中，就是 405
+		if methods := h.allowedMethods(ctx.Req.URL.Path); len(methods) > 0 {
+			ctx.RespHeader().Set("Allow", strings.Join(methods, ", "))
+			ctx.RespStatusCode = http.StatusMethodNotAllowed
+			ctx.RespData = []byte("METHOD NOT ALLOWED")
+			return
+		}
 		// 路由没有命中，就是 404
 		ctx.RespStatusCode = 404
 		ctx.RespData = []byte("NOT FOUND")
@@ -134,10 +189,23 @@ func (h *HTTPServer) serve(ctx *Context) {
 	ctx.PathParams = info.pathParams
 	ctx.MatchedRoute = info.n.route
 	// before execute
-	info.n.handler(ctx)
+	info.n.chain(ctx)
 	// after execute
 }
 
+// addRoute 注册路由，路由级别的 middleware 在这里就组装好，而不是每个请求都组装一次
+// 所以 ServerWithPhaseHook 之类的选项要在注册路由之前设置
+func (h *HTTPServer) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
+	n := h.router.addRoute(method, path, handleFunc, mdls...)
+	handler := handleFunc
+	if h.phaseHook != nil {
+		handler = func(ctx *Context) {
+			ctx.phase(PhaseHandler, handleFunc)
+		}
+	}
+	n.chain = h.chain(mdls, handler)
+}
+
 
 // 在这里注册路由
 // func (h *HTTPServer) AddRoute(method string, path string, handleFunc HandleFunc) {
@@ -157,6 +225,10 @@ func (h *HTTPServer) Options(path string, handleFunc HandleFunc) {
 	h.addRoute(http.MethodOptions, path, handleFunc)
 }
 
+func (h *HTTPServer) Head(path string, handleFunc HandleFunc) {
+	h.addRoute(http.MethodHead, path, handleFunc)
+}
+
 // func (h *HTTPServer) AddRoute1(method string, path string, handleFunc ...HandleFunc) {
 // 	panic("implement me")
 // }
@@ -3,7 +3,10 @@ package web
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestHTTPServer_ServeHTTP(t *testing.T) {
@@ -36,3 +39,145 @@ func TestHTTPServer_ServeHTTP(t *testing.T) {
 	}
 	server.ServeHTTP(nil, &http.Request{})
 }
+
+func TestHTTPServer_flashResp(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mdls    []Middleware
+		handler HandleFunc
+
+		wantCode   int
+		wantBody   string
+		wantHeader http.Header
+		// middleware 看到的响应码
+		wantMdlCode int
+	}{
+		{
+			name: "resp data",
+			handler: func(ctx *Context) {
+				ctx.RespStatusCode = http.StatusAccepted
+				ctx.RespData = []byte("hello")
+			},
+			wantCode:    http.StatusAccepted,
+			wantBody:    "hello",
+			wantMdlCode: http.StatusAccepted,
+			wantHeader:  http.Header{"Content-Length": []string{"5"}},
+		},
+		{
+			// 直接写 Resp 也能被 middleware 看到
+			name: "direct write",
+			handler: func(ctx *Context) {
+				ctx.Resp.WriteHeader(http.StatusBadRequest)
+				_, _ = ctx.Resp.Write([]byte("id 输入不对"))
+			},
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "id 输入不对",
+			wantMdlCode: http.StatusBadRequest,
+		},
+		{
+			name: "direct write without header",
+			handler: func(ctx *Context) {
+				_, _ = ctx.Resp.Write([]byte("hello"))
+			},
+			wantCode:    http.StatusOK,
+			wantBody:    "hello",
+			wantMdlCode: http.StatusOK,
+		},
+		{
+			// middleware 在 handler 之后修改响应头和响应
+			name: "middleware modify",
+			mdls: []Middleware{
+				func(next HandleFunc) HandleFunc {
+					return func(ctx *Context) {
+						next(ctx)
+						ctx.RespHeader().Set("X-Request-Id", "abc")
+						ctx.RespData = []byte("NOT FOUND")
+						ctx.RespStatusCode = http.StatusNotFound
+					}
+				},
+			},
+			handler: func(ctx *Context) {
+				ctx.Resp.Header().Set("Content-Type", "text/plain")
+				_, _ = ctx.Resp.Write([]byte("hello"))
+			},
+			wantCode:    http.StatusNotFound,
+			wantBody:    "NOT FOUND",
+			wantMdlCode: http.StatusNotFound,
+			wantHeader: http.Header{
+				"X-Request-Id":   []string{"abc"},
+				"Content-Type":   []string{"text/plain"},
+				"Content-Length": []string{"9"},
+			},
+		},
+		{
+			// 流式模式下，已经发送的部分不会被重复发送
+			name: "streaming",
+			handler: func(ctx *Context) {
+				ctx.RespData = []byte("hello, ")
+				ctx.SetRespMode(RespModeStreaming)
+				ctx.Resp.Header().Set("Content-Type", "text/plain")
+				_, _ = ctx.Resp.Write([]byte("world"))
+				// 已经发送了，修改没有效果
+				ctx.Resp.WriteHeader(http.StatusBadRequest)
+				ctx.RespHeader().Set("X-Request-Id", "abc")
+			},
+			wantCode:    http.StatusOK,
+			wantBody:    "hello, world",
+			wantMdlCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type": []string{"text/plain"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mdlCode, mdlSize int
+			mdls := append([]Middleware{
+				func(next HandleFunc) HandleFunc {
+					return func(ctx *Context) {
+						next(ctx)
+						mdlCode = ctx.RespStatusCode
+						mdlSize = ctx.RespSize()
+					}
+				},
+			}, tc.mdls...)
+			server := NewHTTPServer(ServerWithMiddleware(mdls...))
+			server.Get("/user", tc.handler)
+			req, err := http.NewRequest(http.MethodGet, "/user", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantMdlCode, mdlCode)
+			assert.Equal(t, len(tc.wantBody), mdlSize)
+			if tc.wantHeader == nil {
+				return
+			}
+			// Result 里面的才是真正发送出去的响应头
+			assert.Equal(t, tc.wantHeader, recorder.Result().Header)
+		})
+	}
+}
+
+func TestHTTPServer_MethodNotAllowed(t *testing.T) {
+	server := NewHTTPServer()
+	server.Get("/user/:id", func(ctx *Context) {})
+	server.Post("/user/:id", func(ctx *Context) {})
+	server.Get("/order", func(ctx *Context) {})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/user/12", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+	assert.Equal(t, "METHOD NOT ALLOWED", recorder.Body.String())
+
+	// 所有方法都没有命中，还是 404
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/user", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "", recorder.Header().Get("Allow"))
+}
@@ -0,0 +1,195 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStreamingUnsupported 底层的 ResponseWriter 不支持 Flush
+var ErrStreamingUnsupported = errors.New("web: 不支持流式响应")
+
+// SSEEvent Server-Sent Events 里面的一个事件
+type SSEEvent struct {
+	// ID 客户端重连的时候，会通过 Last-Event-ID 带回来
+	ID string
+	// Event 事件类型，为空的话客户端触发的是 message 事件
+	Event string
+	// Data 可以有多行，会被拆成多个 data 字段
+	Data string
+	// Retry 告诉客户端断开之后多久重连，0 代表不设置
+	Retry time.Duration
+}
+
+// SSEStream 通过 Context.SSE 创建
+// 它的方法都是并发安全的，所以可以一边发送心跳一边推送事件
+type SSEStream struct {
+	ctx    *Context
+	mutex  sync.Mutex
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// SSE 把响应切换为流式的 text/event-stream，响应头会立刻发送出去
+// 客户端断开的时候，请求的 context 会被取消，此时 Done 会返回
+//
+//	stream, err := ctx.SSE()
+//	if err != nil {
+//		return
+//	}
+//	defer stream.Close()
+//	stream.Heartbeat(15 * time.Second)
+//	for {
+//		select {
+//		case <-stream.Done():
+//			return
+//		case msg := <-ch:
+//			_ = stream.Send(SSEEvent{Data: msg})
+//		}
+//	}
+func (c *Context) SSE() (*SSEStream, error) {
+	flusher, ok := c.Resp.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	if c.RespCommitted() {
+		return nil, errRespCommitted
+	}
+	header := c.RespHeader()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 避免 nginx 之类的代理缓冲响应
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	c.RespData = nil
+	c.SetRespMode(RespModeStreaming)
+	c.Resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	s := &SSEStream{
+		ctx:    c,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		select {
+		case <-c.Done():
+		case <-s.closed:
+		}
+	}()
+	return s, nil
+}
+
+// LastEventID 客户端重连的时候带上来的最后一个事件的 ID
+// 第一次连接的时候是空字符串
+func (s *SSEStream) LastEventID() string {
+	return s.ctx.Req.Header.Get("Last-Event-ID")
+}
+
+// Done 客户端断开，或者调用了 Close 之后返回
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send 发送一个事件
+func (s *SSEStream) Send(e SSEEvent) error {
+	sb := strings.Builder{}
+	if e.ID != "" {
+		sb.WriteString("id: ")
+		sb.WriteString(sseField(e.ID))
+		sb.WriteByte('\n')
+	}
+	if e.Event != "" {
+		sb.WriteString("event: ")
+		sb.WriteString(sseField(e.Event))
+		sb.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		sb.WriteString("retry: ")
+		sb.WriteString(strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		sb.WriteByte('\n')
+	}
+	// 规范里面 \r\n, \r, \n 都是换行
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	sb.WriteByte('\n')
+	return s.write(sb.String())
+}
+
+// SendJSON 把 val 编码成 JSON 作为 data 发送
+func (s *SSEStream) SendJSON(event string, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return s.Send(SSEEvent{Event: event, Data: string(data)})
+}
+
+// Comment 发送注释，客户端会忽略它，一般用来保持连接
+func (s *SSEStream) Comment(text string) error {
+	return s.write(": " + sseField(text) + "\n\n")
+}
+
+// Heartbeat 每隔 interval 发送一个注释，避免连接被代理因为空闲而断开
+// 客户端断开或者 Close 之后停止
+func (s *SSEStream) Heartbeat(interval time.Duration) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Comment("ping"); err != nil {
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止心跳，不会关闭连接，连接在 handler 返回之后由 net/http 处理
+func (s *SSEStream) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	s.wg.Wait()
+}
+
+func (s *SSEStream) write(frame string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+		return errors.New("web: SSE 已经关闭")
+	default:
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.ctx.Resp.Write([]byte(frame)); err != nil {
+		return err
+	}
+	s.ctx.Resp.(http.Flusher).Flush()
+	return nil
+}
+
+// sseField id、event 和注释里面不能有换行，不然就会被当成另外一个字段
+func sseField(val string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(val)
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext_SSE(t *testing.T) {
+	server := NewHTTPServer()
+	server.Get("/events", func(ctx *Context) {
+		// 这个头部会被 SSE 覆盖掉
+		ctx.RespHeader().Set("Content-Type", "application/json")
+		stream, err := ctx.SSE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		_ = stream.Send(SSEEvent{ID: "2", Event: "order", Data: "line1\nline2\r\nline3", Retry: 3 * time.Second})
+		_ = stream.Send(SSEEvent{ID: "3\n", Data: "resume from " + stream.LastEventID()})
+		_ = stream.SendJSON("user", map[string]string{"name": "Tom"})
+		_ = stream.Comment("bye")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	resp := recorder.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, "id: 2\nevent: order\nretry: 3000\ndata: line1\ndata: line2\ndata: line3\n\n"+
+		"id: 3\ndata: resume from 1\n\n"+
+		"event: user\ndata: {\"name\":\"Tom\"}\n\n"+
+		": bye\n\n", recorder.Body.String())
+}
+
+func TestSSEStream_Heartbeat(t *testing.T) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(reqCtx)
+	recorder := httptest.NewRecorder()
+	ctx := &Context{Req: req, Resp: recorder}
+
+	stream, err := ctx.SSE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Heartbeat(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	// 客户端断开
+	cancel()
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Fatal("客户端断开之后没有结束")
+	}
+	assert.Equal(t, context.Canceled, stream.Send(SSEEvent{Data: "hello"}))
+	stream.Close()
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), ": ping\n\n"))
+	assert.NotContains(t, recorder.Body.String(), "hello")
+}
+
+func TestContext_SSEUnsupported(t *testing.T) {
+	ctx := &Context{Req: httptest.NewRequest(http.MethodGet, "/events", nil), Resp: struct{ http.ResponseWriter }{}}
+	_, err := ctx.SSE()
+	assert.Equal(t, ErrStreamingUnsupported, err)
+}
@@ -0,0 +1,331 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StaticResourceHandler 静态资源处理
+// 要注册在以 *filepath 这种通配符结尾的路由上，比如说：
+//
+//	h := NewStaticResourceHandler("./public")
+//	server.Get("/static/*filepath", h.Handle)
+//	server.Head("/static/*filepath", h.Handle)
+//
+// 那么 /static/js/app.js 对应的就是 ./public/js/app.js
+// Range、ETag、Last-Modified 以及各种条件请求是交给 http.ServeContent 处理的
+type StaticResourceHandler struct {
+	fsys fs.FS
+
+	indexFiles []string
+	listDir    bool
+	// spaIndex 不为空的话，找不到的页面都返回这个文件
+	spaIndex string
+	// 后缀 => Cache-Control，空字符串代表默认值
+	cacheControl map[string]string
+	// 是否查找预先压缩好的 .br 和 .gz 文件
+	precompressed bool
+
+	// 没有修改时间的文件，比如说 embed.FS，用内容的哈希作为 ETag
+	// embed.FS 是不会变的，所以可以一直缓存
+	etags sync.Map
+}
+
+type StaticResourceHandlerOption func(h *StaticResourceHandler)
+
+// NewStaticResourceHandler 从 dir 目录读取文件
+func NewStaticResourceHandler(dir string, opts ...StaticResourceHandlerOption) *StaticResourceHandler {
+	return NewStaticResourceHandlerFS(os.DirFS(dir), opts...)
+}
+
+// NewStaticResourceHandlerFS 从 fsys 读取文件，比如说 embed.FS
+// 如果 embed 的时候带上了目录名，那么可以用 fs.Sub 去掉
+func NewStaticResourceHandlerFS(fsys fs.FS, opts ...StaticResourceHandlerOption) *StaticResourceHandler {
+	h := &StaticResourceHandler{
+		fsys:         fsys,
+		indexFiles:   []string{"index.html"},
+		cacheControl: map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// StaticWithIndex 访问目录的时候，按顺序查找的文件，默认是 index.html
+func StaticWithIndex(files ...string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.indexFiles = files
+	}
+}
+
+// StaticWithDirListing 目录下没有 index 文件的时候，列出目录下的文件
+func StaticWithDirListing() StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.listDir = true
+	}
+}
+
+// StaticWithSPA 单页应用，没有后缀的路径找不到的时候，返回 index
+// 而 /app.js 这种有后缀的找不到还是 404，避免把 HTML 当成脚本返回
+func StaticWithSPA(index string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.spaIndex = index
+	}
+}
+
+// StaticWithCacheControl 设置这些后缀的 Cache-Control，后缀带上 .，比如说 .js
+// 没有传入后缀的话，就是默认值
+func StaticWithCacheControl(value string, exts ...string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		if len(exts) == 0 {
+			h.cacheControl[""] = value
+		}
+		for _, ext := range exts {
+			h.cacheControl[strings.ToLower(ext)] = value
+		}
+	}
+}
+
+// StaticWithPrecompressed 客户端支持的话，优先返回同名的 .br 或者 .gz 文件
+// 比如说请求 app.js，存在 app.js.br 就直接返回它
+func StaticWithPrecompressed() StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.precompressed = true
+	}
+}
+
+func (h *StaticResourceHandler) Handle(ctx *Context) {
+	name, ok := h.fileName(ctx)
+	if !ok {
+		h.notFound(ctx)
+		return
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && h.spaIndex != "" && path.Ext(name) == "" {
+			h.serveFile(ctx, h.spaIndex)
+			return
+		}
+		h.fail(ctx, err)
+		return
+	}
+	if info.IsDir() {
+		h.serveDir(ctx, name)
+		return
+	}
+	h.serveFile(ctx, name)
+}
+
+// fileName 把请求的路径转换为 fs.FS 里面的文件名
+// 文件名就是路由最后一段通配符匹配到的部分，比如说 /:tenant/static/*filepath 里面 filepath 的值
+func (h *StaticResourceHandler) fileName(ctx *Context) (string, bool) {
+	reqPath := ctx.Req.URL.Path
+	if strings.ContainsAny(reqPath, "\\\x00") {
+		return "", false
+	}
+	route := ctx.MatchedRoute
+	var rest string
+	switch last := route[strings.LastIndexByte(route, '/')+1:]; {
+	case len(last) > 1 && last[0] == '*':
+		rest = ctx.PathParams[last[1:]]
+	case last == "*":
+		// * 只匹配一段
+		rest = path.Base(reqPath)
+	default:
+		rest = strings.TrimPrefix(reqPath, route)
+	}
+	// 先把路径当成绝对路径来清理，这样 .. 最多只能到根目录，不会跑出去
+	name := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+func (h *StaticResourceHandler) serveDir(ctx *Context, name string) {
+	// 目录必须以 / 结尾，不然页面里面的相对路径就不对了
+	// 和 http.FileServer 一样使用相对路径，
+	// 不然 //evil.com 这种路径会被浏览器当成另外一个网站，变成开放重定向
+	if !strings.HasSuffix(ctx.Req.URL.Path, "/") {
+		target := path.Base(ctx.Req.URL.Path) + "/"
+		if ctx.Req.URL.RawQuery != "" {
+			target += "?" + ctx.Req.URL.RawQuery
+		}
+		ctx.RespHeader().Set("Location", target)
+		ctx.RespStatusCode = http.StatusMovedPermanently
+		return
+	}
+	for _, index := range h.indexFiles {
+		file := path.Join(name, index)
+		if info, err := fs.Stat(h.fsys, file); err == nil && !info.IsDir() {
+			h.serveFile(ctx, file)
+			return
+		}
+	}
+	if !h.listDir {
+		h.notFound(ctx)
+		return
+	}
+	entries, err := fs.ReadDir(h.fsys, name)
+	if err != nil {
+		h.fail(ctx, err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		u := url.URL{Path: entryName}
+		fmt.Fprintf(buf, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(entryName))
+	}
+	buf.WriteString("</pre>\n")
+	ctx.HTML(http.StatusOK, buf.String())
+}
+
+func (h *StaticResourceHandler) serveFile(ctx *Context, name string) {
+	file, info, encoding, err := h.open(ctx, name)
+	if err != nil {
+		h.fail(ctx, err)
+		return
+	}
+	defer file.Close()
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			h.fail(ctx, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	etag, err := h.etag(name+"."+encoding, info, content)
+	if err != nil {
+		h.fail(ctx, err)
+		return
+	}
+
+	header := ctx.RespHeader()
+	header.Set("ETag", etag)
+	if cc, ok := h.cacheControl[strings.ToLower(path.Ext(name))]; ok {
+		header.Set("Cache-Control", cc)
+	} else if cc, ok = h.cacheControl[""]; ok {
+		header.Set("Cache-Control", cc)
+	}
+	if h.precompressed {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	// 文件可能很大，所以不缓存在 RespData 里面，直接写出去
+	ctx.SetRespMode(RespModeStreaming)
+	// 传入的是原本的文件名，这样 Content-Type 就是按照原本的后缀来判断的
+	http.ServeContent(ctx.Resp, ctx.Req, name, info.ModTime(), content)
+}
+
+// open 打开文件，启用了 precompressed 的话，优先打开压缩过的文件
+// 第三个返回值是压缩的方式，没有压缩的话就是空字符串
+func (h *StaticResourceHandler) open(ctx *Context, name string) (fs.File, fs.FileInfo, string, error) {
+	if h.precompressed {
+		accept := ctx.Req.Header.Get("Accept-Encoding")
+		for _, c := range []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+			if !acceptsEncoding(accept, c.encoding) {
+				continue
+			}
+			if file, info, err := h.openFile(name + c.ext); err == nil {
+				return file, info, c.encoding, nil
+			}
+		}
+	}
+	file, info, err := h.openFile(name)
+	return file, info, "", err
+}
+
+func (h *StaticResourceHandler) openFile(name string) (fs.File, fs.FileInfo, error) {
+	file, err := h.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		_ = file.Close()
+		if err == nil {
+			err = fs.ErrNotExist
+		}
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// etag 有修改时间的用修改时间和大小，没有的用内容的哈希
+func (h *StaticResourceHandler) etag(key string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	if val, ok := h.etags.Load(key); ok {
+		return val.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	h.etags.Store(key, etag)
+	return etag, nil
+}
+
+func (h *StaticResourceHandler) notFound(ctx *Context) {
+	ctx.RespStatusCode = http.StatusNotFound
+	ctx.RespData = []byte("NOT FOUND")
+}
+
+func (h *StaticResourceHandler) fail(ctx *Context, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		h.notFound(ctx)
+	case errors.Is(err, fs.ErrPermission):
+		ctx.RespStatusCode = http.StatusForbidden
+		ctx.RespData = []byte("FORBIDDEN")
+	default:
+		ctx.HandlerErr = err
+		ctx.RespStatusCode = http.StatusInternalServerError
+		ctx.RespData = []byte("INTERNAL SERVER ERROR")
+	}
+}
+
+// acceptsEncoding Accept-Encoding 里面有没有 encoding，q=0 代表不接受
+func acceptsEncoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimSpace(params), "=")
+		if ok && strings.TrimSpace(key) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
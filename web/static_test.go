@@ -0,0 +1,272 @@
+package web
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticResourceHandler_Handle(t *testing.T) {
+	modTime := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("<p>home</p>"), ModTime: modTime},
+		"js/app.js":          {Data: []byte("console.log('hello')"), ModTime: modTime},
+		"js/app.js.br":       {Data: []byte("br data"), ModTime: modTime},
+		"docs/a.txt":         {Data: []byte("0123456789"), ModTime: modTime},
+		"docs/<b>.txt":       {Data: []byte("b"), ModTime: modTime},
+		"img/logo.png":       {Data: []byte("png"), ModTime: modTime},
+		"empty/sub/file.txt": {Data: []byte("file"), ModTime: modTime},
+	}
+	h := NewStaticResourceHandlerFS(fsys,
+		StaticWithDirListing(),
+		StaticWithSPA("index.html"),
+		StaticWithPrecompressed(),
+		StaticWithCacheControl("no-cache"),
+		StaticWithCacheControl("public, max-age=31536000", ".js", ".png"))
+	server := NewHTTPServer()
+	server.Get("/static/*filepath", h.Handle)
+	server.Head("/static/*filepath", h.Handle)
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		header map[string]string
+
+		wantCode   int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:     "file",
+			path:     "/static/js/app.js",
+			wantCode: http.StatusOK,
+			wantBody: "console.log('hello')",
+			wantHeader: map[string]string{
+				"Cache-Control":  "public, max-age=31536000",
+				"Content-Length": "20",
+				"Last-Modified":  "Sat, 01 Oct 2022 00:00:00 GMT",
+				"Vary":           "Accept-Encoding",
+			},
+		},
+		{
+			name:     "head",
+			method:   http.MethodHead,
+			path:     "/static/img/logo.png",
+			wantCode: http.StatusOK,
+			wantHeader: map[string]string{
+				"Content-Type":   "image/png",
+				"Content-Length": "3",
+			},
+		},
+		{
+			name:     "precompressed",
+			path:     "/static/js/app.js",
+			header:   map[string]string{"Accept-Encoding": "gzip, br"},
+			wantCode: http.StatusOK,
+			wantBody: "br data",
+			wantHeader: map[string]string{
+				"Content-Encoding": "br",
+				"Content-Type":     "text/javascript; charset=utf-8",
+			},
+		},
+		{
+			name:     "precompressed not accepted",
+			path:     "/static/js/app.js",
+			header:   map[string]string{"Accept-Encoding": "br;q=0"},
+			wantCode: http.StatusOK,
+			wantBody: "console.log('hello')",
+		},
+		{
+			name:     "if-modified-since",
+			path:     "/static/docs/a.txt",
+			header:   map[string]string{"If-Modified-Since": "Sat, 01 Oct 2022 00:00:00 GMT"},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "range",
+			path:     "/static/docs/a.txt",
+			header:   map[string]string{"Range": "bytes=2-4"},
+			wantCode: http.StatusPartialContent,
+			wantBody: "234",
+			wantHeader: map[string]string{
+				"Content-Range": "bytes 2-4/10",
+				"Cache-Control": "no-cache",
+			},
+		},
+		{
+			name:     "range not satisfiable",
+			path:     "/static/docs/a.txt",
+			header:   map[string]string{"Range": "bytes=20-30"},
+			wantCode: http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:     "index",
+			path:     "/static/",
+			wantCode: http.StatusOK,
+			wantBody: "<p>home</p>",
+		},
+		{
+			name:     "dir redirect",
+			path:     "/static/docs",
+			wantCode: http.StatusMovedPermanently,
+			wantHeader: map[string]string{
+				"Location": "docs/",
+			},
+		},
+		{
+			name:     "dir listing",
+			path:     "/static/docs/",
+			wantCode: http.StatusOK,
+			wantBody: "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n" +
+				"<a href=\"%3Cb%3E.txt\">&lt;b&gt;.txt</a>\n<a href=\"a.txt\">a.txt</a>\n</pre>\n",
+		},
+		{
+			name:     "spa fallback",
+			path:     "/static/user/profile",
+			wantCode: http.StatusOK,
+			wantBody: "<p>home</p>",
+		},
+		{
+			// 有后缀的不会回退到 index.html
+			name:     "asset not found",
+			path:     "/static/js/unknown.js",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "traversal",
+			path:     "/static/../../index.html",
+			wantCode: http.StatusOK,
+			wantBody: "<p>home</p>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			resp := recorder.Result()
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+			}
+			for k, v := range tc.wantHeader {
+				assert.Equal(t, v, resp.Header.Get(k), k)
+			}
+		})
+	}
+}
+
+func TestStaticResourceHandler_etag(t *testing.T) {
+	// 没有修改时间，比如说 embed.FS
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("0123456789")},
+		"evil.com/a.txt": {Data: []byte("a")},
+	}
+	server := NewHTTPServer()
+	server.Get("/*filepath", NewStaticResourceHandlerFS(fsys).Handle)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	// 没有 index.html，也没有开启目录列表
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// 重定向必须是相对路径，不然就是跳转到 evil.com
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "//evil.com", nil))
+	assert.Equal(t, http.StatusMovedPermanently, recorder.Code)
+	assert.Equal(t, "evil.com/", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+	etag := recorder.Result().Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Empty(t, recorder.Result().Header.Get("Last-Modified"))
+
+	req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	req.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+	// 多个范围
+	req = httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,5-6")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(recorder.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+	assert.Equal(t, []string{"bytes 0-1/10 01", "bytes 5-6/10 56"}, parts)
+}
+
+func TestStaticResourceHandler_dir(t *testing.T) {
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public")
+	if err := os.Mkdir(public, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(public, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPServer()
+	server.Get("/static/*filepath", NewStaticResourceHandler(public).Handle)
+
+	for _, path := range []string{"/static/a.txt", "/static/../secret.txt", "/static/..%2fsecret.txt"} {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if strings.HasSuffix(path, "a.txt") {
+			assert.Equal(t, "a", recorder.Body.String())
+			continue
+		}
+		assert.Equal(t, http.StatusNotFound, recorder.Code, path)
+		assert.NotContains(t, recorder.Body.String(), "secret")
+	}
+
+	// 通配符前面有路径参数
+	server.Get("/:tenant/assets/*filepath", NewStaticResourceHandler(public).Handle)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/acme/assets/a.txt", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "a", recorder.Body.String())
+}
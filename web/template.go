@@ -0,0 +1,263 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// TemplateEngine 模板引擎
+// 框架只关心渲染，至于模板怎么加载，怎么组织，是具体实现的事情
+type TemplateEngine interface {
+	// Render 渲染名字为 tplName 的模板，返回渲染结果
+	// data 是渲染页面所需要的数据
+	Render(ctx context.Context, tplName string, data any) ([]byte, error)
+}
+
+// View 代表一个需要用模板引擎渲染的页面
+// 传给 Render 的时候，会用服务器上的 TemplateEngine 渲染为 HTML
+type View struct {
+	Name string
+	Data any
+}
+
+// ServerWithTemplateEngine 设置模板引擎
+func ServerWithTemplateEngine(engine TemplateEngine) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.tplEngine = engine
+	}
+}
+
+// RenderTemplate 用模板引擎渲染 tplName，等价于 Render(status, View{...})
+func (c *Context) RenderTemplate(status int, tplName string, data any) error {
+	return c.Render(status, View{Name: tplName, Data: data})
+}
+
+func (c *Context) renderView(status int, view View) error {
+	c.RespHeader().Add("Vary", "Accept")
+	if c.Negotiate("text/html") == "" {
+		c.RespStatusCode = http.StatusNotAcceptable
+		c.RespData = nil
+		return ErrNotAcceptable
+	}
+	if c.server == nil || c.server.tplEngine == nil {
+		return errors.New("web: 没有设置模板引擎")
+	}
+	data, err := c.server.tplEngine.Render(c, view.Name, view.Data)
+	if err != nil {
+		return err
+	}
+	c.HTML(status, string(data))
+	return nil
+}
+
+// GoTemplateEngine 基于 html/template 的实现，输出会被自动转义
+// 模板的名字就是文件在 fs.FS 里面的路径，比如说 admin/index.gohtml
+// 每一个页面都和所有的布局、片段一起解析为一个独立的模板集合，
+// 所以不同的页面可以定义同名的 block
+type GoTemplateEngine struct {
+	fsys     fs.FS
+	pages    []string
+	layouts  []string
+	partials []string
+	layout   string
+	// 匹配这些模式的页面不使用布局
+	noLayouts []string
+	funcs     template.FuncMap
+	reload    bool
+
+	mutex sync.RWMutex
+	// 页面名字 => 解析好的模板
+	tpls map[string]*template.Template
+	// 上一次加载的时候，所有文件的修改时间
+	modTimes map[string]time.Time
+}
+
+type GoTemplateEngineOption func(e *GoTemplateEngine)
+
+// NewGoTemplateEngine 从 fsys 里面加载模板，fsys 可以是 os.DirFS，也可以是 embed.FS
+// pattern 是页面的匹配模式，比如说 "*.gohtml", "admin/*.gohtml"
+func NewGoTemplateEngine(fsys fs.FS, pattern string, opts ...GoTemplateEngineOption) (*GoTemplateEngine, error) {
+	e := &GoTemplateEngine{
+		fsys:  fsys,
+		pages: []string{pattern},
+		funcs: template.FuncMap{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GoTemplateWithLayout 设置布局文件
+// name 是默认使用的布局模板，页面通过 {{define "content"}} 之类的方式填充布局里面的 block
+func GoTemplateWithLayout(pattern string, name string) GoTemplateEngineOption {
+	return func(e *GoTemplateEngine) {
+		e.layouts = append(e.layouts, pattern)
+		e.layout = name
+	}
+}
+
+// GoTemplateWithoutLayout 匹配 patterns 的页面直接渲染，不套用布局
+// 比如说局部刷新用的 HTML 片段，或者邮件模板，pattern 的语法和 path.Match 一样
+func GoTemplateWithoutLayout(patterns ...string) GoTemplateEngineOption {
+	return func(e *GoTemplateEngine) {
+		e.noLayouts = append(e.noLayouts, patterns...)
+	}
+}
+
+// GoTemplateWithPartials 设置片段，比如说页头页脚
+// 页面里面通过 {{template "partials/header.gohtml" .}} 来引用
+func GoTemplateWithPartials(pattern string) GoTemplateEngineOption {
+	return func(e *GoTemplateEngine) {
+		e.partials = append(e.partials, pattern)
+	}
+}
+
+// GoTemplateWithFuncs 注册模板里面可以使用的函数
+func GoTemplateWithFuncs(funcs template.FuncMap) GoTemplateEngineOption {
+	return func(e *GoTemplateEngine) {
+		for name, fn := range funcs {
+			e.funcs[name] = fn
+		}
+	}
+}
+
+// GoTemplateWithReload 开发模式，每次渲染之前都检查文件有没有修改，有修改就重新加载
+// embed.FS 里面的文件没有修改时间，所以这个选项对它没有效果
+func GoTemplateWithReload() GoTemplateEngineOption {
+	return func(e *GoTemplateEngine) {
+		e.reload = true
+	}
+}
+
+func (e *GoTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	if e.reload && e.changed() {
+		if err := e.load(); err != nil {
+			return nil, err
+		}
+	}
+	e.mutex.RLock()
+	tpl, ok := e.tpls[tplName]
+	e.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("web: 模板 %s 不存在", tplName)
+	}
+	name := tplName
+	if e.layout != "" && e.useLayout(tplName) && tpl.Lookup(e.layout) != nil {
+		name = e.layout
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.ExecuteTemplate(buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *GoTemplateEngine) useLayout(tplName string) bool {
+	for _, pattern := range e.noLayouts {
+		if ok, _ := path.Match(pattern, tplName); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// load 重新解析所有的模板
+func (e *GoTemplateEngine) load() error {
+	modTimes := make(map[string]time.Time, 16)
+	base := template.New("").Funcs(e.funcs)
+	shared, err := e.glob(append(append([]string{}, e.layouts...), e.partials...))
+	if err != nil {
+		return err
+	}
+	for _, name := range shared {
+		if err = e.parse(base, name, modTimes); err != nil {
+			return err
+		}
+	}
+	pages, err := e.glob(e.pages)
+	if err != nil {
+		return err
+	}
+	tpls := make(map[string]*template.Template, len(pages))
+	for _, name := range pages {
+		tpl, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if err = e.parse(tpl, name, modTimes); err != nil {
+			return err
+		}
+		tpls[name] = tpl
+	}
+	e.mutex.Lock()
+	e.tpls = tpls
+	e.modTimes = modTimes
+	e.mutex.Unlock()
+	return nil
+}
+
+func (e *GoTemplateEngine) glob(patterns []string) ([]string, error) {
+	var res []string
+	for _, pattern := range patterns {
+		names, err := fs.Glob(e.fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, names...)
+	}
+	return res, nil
+}
+
+func (e *GoTemplateEngine) parse(tpl *template.Template, name string, modTimes map[string]time.Time) error {
+	data, err := fs.ReadFile(e.fsys, name)
+	if err != nil {
+		return err
+	}
+	if _, err = tpl.New(path.Clean(name)).Parse(string(data)); err != nil {
+		return err
+	}
+	if info, err := fs.Stat(e.fsys, name); err == nil {
+		modTimes[name] = info.ModTime()
+	}
+	return nil
+}
+
+// changed 有没有文件被修改，或者新增、删除了文件
+func (e *GoTemplateEngine) changed() bool {
+	names, err := e.glob(append(append(append([]string{}, e.layouts...), e.partials...), e.pages...))
+	if err != nil {
+		return true
+	}
+	// 页面的模式可能也匹配到了布局和片段，去重之后才能和上一次的比较
+	uniq := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		uniq[name] = struct{}{}
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if len(uniq) != len(e.modTimes) {
+		return true
+	}
+	for name := range uniq {
+		info, err := fs.Stat(e.fsys, name)
+		if err != nil {
+			return true
+		}
+		if modTime, ok := e.modTimes[name]; !ok || !modTime.Equal(info.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
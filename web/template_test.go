@@ -0,0 +1,167 @@
+package web
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoTemplateEngine_Render(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.gohtml": {Data: []byte(
+			`{{define "base"}}<html>{{template "partials/header.gohtml" .}}{{block "content" .}}{{end}}</html>{{end}}`)},
+		"partials/header.gohtml": {Data: []byte(`<h1>{{.Title | upper}}</h1>`)},
+		"pages/user.gohtml":      {Data: []byte(`{{define "content"}}<p>{{.Name}}</p>{{end}}`)},
+		"pages/order.gohtml":     {Data: []byte(`{{define "content"}}<p>order {{.ID}}</p>{{end}}`)},
+	}
+	engine, err := NewGoTemplateEngine(fsys, "pages/*.gohtml",
+		GoTemplateWithLayout("layouts/*.gohtml", "base"),
+		GoTemplateWithPartials("partials/*.gohtml"),
+		GoTemplateWithFuncs(template.FuncMap{"upper": strings.ToUpper}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		tplName string
+		data    any
+
+		wantRes string
+		wantErr string
+	}{
+		{
+			name:    "layout and partial",
+			tplName: "pages/user.gohtml",
+			data:    map[string]string{"Title": "user", "Name": "Tom"},
+			wantRes: "<html><h1>USER</h1><p>Tom</p></html>",
+		},
+		{
+			// 不同页面定义的 content 互不影响
+			name:    "another page",
+			tplName: "pages/order.gohtml",
+			data:    map[string]any{"Title": "order", "ID": 12},
+			wantRes: "<html><h1>ORDER</h1><p>order 12</p></html>",
+		},
+		{
+			name:    "escape",
+			tplName: "pages/user.gohtml",
+			data:    map[string]string{"Title": "user", "Name": "<script>"},
+			wantRes: "<html><h1>USER</h1><p>&lt;script&gt;</p></html>",
+		},
+		{
+			name:    "not found",
+			tplName: "pages/unknown.gohtml",
+			wantErr: "web: 模板 pages/unknown.gohtml 不存在",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := engine.Render(context.Background(), tc.tplName, tc.data)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantRes, string(res))
+		})
+	}
+}
+
+func TestGoTemplateWithoutLayout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.gohtml":          {Data: []byte(`{{define "base"}}<html>{{block "content" .}}{{end}}</html>{{end}}`)},
+		"fragments/row.gohtml": {Data: []byte(`<tr>{{.}}</tr>`)},
+	}
+	engine, err := NewGoTemplateEngine(fsys, "*/*.gohtml",
+		GoTemplateWithLayout("base.gohtml", "base"),
+		GoTemplateWithoutLayout("fragments/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := engine.Render(context.Background(), "fragments/row.gohtml", "Tom")
+	assert.NoError(t, err)
+	assert.Equal(t, "<tr>Tom</tr>", string(res))
+}
+
+func TestGoTemplateEngine_changed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.gohtml": {Data: []byte(`{{define "base"}}<html>{{block "content" .}}{{end}}</html>{{end}}`)},
+		"user.gohtml": {Data: []byte(`{{define "content"}}<p>{{.}}</p>{{end}}`)},
+	}
+	// 页面的模式也匹配到了布局文件
+	engine, err := NewGoTemplateEngine(fsys, "*.gohtml",
+		GoTemplateWithLayout("base.gohtml", "base"),
+		GoTemplateWithReload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, engine.changed())
+	fsys["order.gohtml"] = &fstest.MapFile{Data: []byte(`order`)}
+	assert.True(t, engine.changed())
+}
+
+func TestGoTemplateEngine_reload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hello.gohtml")
+	if err := os.WriteFile(file, []byte(`hello {{.}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewGoTemplateEngine(os.DirFS(dir), "*.gohtml", GoTemplateWithReload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := engine.Render(context.Background(), "hello.gohtml", "Tom")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello Tom", string(res))
+
+	if err = os.WriteFile(file, []byte(`hi {{.}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 有些文件系统修改时间的精度不高，所以手动改一下
+	modTime := time.Now().Add(time.Minute)
+	if err = os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	res, err = engine.Render(context.Background(), "hello.gohtml", "Tom")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi Tom", string(res))
+}
+
+func TestContext_RenderTemplate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello.gohtml": {Data: []byte(`<p>{{.}}</p>`)},
+	}
+	engine, err := NewGoTemplateEngine(fsys, "*.gohtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPServer(ServerWithTemplateEngine(engine))
+
+	req, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Req: req, server: server}
+	err = ctx.RenderTemplate(http.StatusCreated, "hello.gohtml", "<Tom>")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, ctx.RespStatusCode)
+	assert.Equal(t, "<p>&lt;Tom&gt;</p>", string(ctx.RespData))
+	assert.Equal(t, "text/html; charset=utf-8", ctx.RespHeader().Get("Content-Type"))
+
+	// 客户端不接受 HTML
+	req.Header.Set("Accept", "application/json")
+	ctx = &Context{Req: req, server: server}
+	err = ctx.Render(http.StatusOK, View{Name: "hello.gohtml", Data: "Tom"})
+	assert.Equal(t, ErrNotAcceptable, err)
+	assert.Equal(t, http.StatusNotAcceptable, ctx.RespStatusCode)
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+// TimingRoute 框架自动记录的查找路由的耗时
+const TimingRoute = "route"
+
+// Timing 处理请求过程中的一个阶段的耗时
+// 最终会被 servertiming 之类的 middleware 输出到 Server-Timing 响应头里面
+type Timing struct {
+	Name  string
+	Desc  string
+	Start time.Time
+	// Duration 还没有结束的阶段是 0
+	Duration time.Duration
+	Done     bool
+}
+
+// timings 用户可能在自己开的 goroutine 里面记录，所以要加锁
+type timings struct {
+	mutex sync.Mutex
+	items []*Timing
+}
+
+// Timing 开始记录一个阶段，调用返回的函数结束记录，多次调用只有第一次有效
+// 一般的用法是：
+//
+//	defer ctx.Timing("db", "查询用户")()
+func (c *Context) Timing(name, desc string) (stop func()) {
+	t := &Timing{Name: name, Desc: desc, Start: time.Now()}
+	c.timings.mutex.Lock()
+	c.timings.items = append(c.timings.items, t)
+	c.timings.mutex.Unlock()
+	return func() {
+		c.timings.mutex.Lock()
+		defer c.timings.mutex.Unlock()
+		if t.Done {
+			return
+		}
+		t.Duration = time.Since(t.Start)
+		t.Done = true
+	}
+}
+
+// Timings 按照开始的顺序返回已经记录的阶段，返回的是副本
+func (c *Context) Timings() []Timing {
+	c.timings.mutex.Lock()
+	defer c.timings.mutex.Unlock()
+	res := make([]Timing, 0, len(c.timings.items))
+	for _, t := range c.timings.items {
+		res = append(res, *t)
+	}
+	return res
+}
+
+// StartTime 框架开始处理请求的时间，用来计算总的耗时
+// 不是框架创建的 Context 返回零值
+func (c *Context) StartTime() time.Time {
+	return c.startTime
+}
+
+// BeforeCommit 注册在响应头发送之前执行的回调，这是修改响应头的最后机会
+// 不管是缓冲模式下的 flashResp，还是流式模式下的第一次写入，都会执行，并且只会执行一次
+// 回调按照注册的相反顺序执行，和 defer 一样
+func (c *Context) BeforeCommit(fn func()) {
+	if c.rw == nil {
+		c.rw = newResponseWriter(c, nil)
+	}
+	c.rw.beforeCommit = append(c.rw.beforeCommit, fn)
+}
+
+// AfterFlush 注册在响应发送之后执行的回调，这个时候所有的 middleware 都已经返回了
+// 比如说 span 要把发送响应的时间也包括进去，就要在这里结束。
+// 即便发生了 panic 也会执行。回调按照注册的相反顺序执行，和 defer 一样，
+// 所以应该在 next 之前注册，这样里层 middleware 的回调先执行
+func (c *Context) AfterFlush(fn func()) {
+	c.afterFlush = append(c.afterFlush, fn)
+}
+
+func (c *Context) runAfterFlush() {
+	for i := len(c.afterFlush) - 1; i >= 0; i-- {
+		c.afterFlush[i]()
+	}
+	c.afterFlush = nil
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext_Timing(t *testing.T) {
+	var timings []Timing
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			ctx.BeforeCommit(func() {
+				ctx.RespHeader().Set("X-Order", ctx.RespHeader().Get("X-Order")+"1")
+				timings = ctx.Timings()
+			})
+			ctx.BeforeCommit(func() {
+				ctx.RespHeader().Set("X-Order", ctx.RespHeader().Get("X-Order")+"2")
+			})
+			next(ctx)
+		}
+	}))
+	server.Get("/user", func(ctx *Context) {
+		stop := ctx.Timing("db", "查询用户")
+		stop()
+		stop()
+		ctx.Timing("cache", "")
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	// 和 defer 一样，后注册的先执行
+	assert.Equal(t, "21", recorder.Header().Get("X-Order"))
+	assert.Equal(t, 3, len(timings))
+	assert.Equal(t, TimingRoute, timings[0].Name)
+	assert.True(t, timings[0].Done)
+	assert.Equal(t, "db", timings[1].Name)
+	assert.Equal(t, "查询用户", timings[1].Desc)
+	assert.True(t, timings[1].Done)
+	assert.Equal(t, "cache", timings[2].Name)
+	assert.False(t, timings[2].Done)
+}
+
+func TestContext_AfterFlush(t *testing.T) {
+	var order []string
+	recorder := httptest.NewRecorder()
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			ctx.AfterFlush(func() {
+				// 响应已经发出去了
+				order = append(order, "outer "+recorder.Body.String())
+			})
+			ctx.AfterFlush(func() {
+				order = append(order, "inner")
+			})
+			next(ctx)
+		}
+	}))
+	server.Get("/user", func(ctx *Context) {
+		ctx.RespData = []byte("hello")
+	})
+	server.Get("/panic", func(ctx *Context) {
+		panic("boom")
+	})
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, []string{"inner", "outer hello"}, order)
+
+	// panic 的时候也会执行
+	order = nil
+	recorder = httptest.NewRecorder()
+	assert.Panics(t, func() {
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	assert.Equal(t, []string{"inner", "outer "}, order)
+}
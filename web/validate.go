@@ -0,0 +1,472 @@
+package web
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultValidator 没有经过 HTTPServer 创建的 Context 使用这个
+var defaultValidator = NewValidator()
+
+// FieldValue 是传给校验规则的参数
+type FieldValue struct {
+	// Value 字段的值，如果字段是指针，那么这里已经解引用了
+	Value reflect.Value
+	// Param 规则的参数，比如说 min=1 里面的 1
+	Param string
+	// Parent 字段所在的结构体，跨字段的校验规则需要用到
+	Parent reflect.Value
+}
+
+// ValidateFunc 校验规则，返回 false 代表校验失败
+type ValidateFunc func(field FieldValue) bool
+
+// FieldError 某个字段校验失败
+type FieldError struct {
+	// Field 字段的路径，优先使用 json 标签里面的名字
+	// 嵌套的结构体用 . 连接，切片用下标，比如说 items[0].name
+	Field string `json:"field"`
+	// Tag 失败的规则，比如说 min
+	Tag string `json:"tag"`
+	// Param 规则的参数
+	Param string `json:"param,omitempty"`
+	// Message 错误信息，可以通过 Validator.Translate 转换为别的语言
+	Message string `json:"message"`
+	Value   any    `json:"-"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors 聚合了所有字段的校验错误
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "web: 校验失败: " + strings.Join(msgs, "; ")
+}
+
+// Validator 根据 validate 标签校验结构体，例如
+//
+//	validate:"required,min=1,max=64"
+//	validate:"omitempty,email"
+//	validate:"oneof=a b"
+//	validate:"eqfield=Password"
+//	validate:"dive,min=1"  dive 之后的规则应用在切片的每一个元素上
+//
+// 嵌套的结构体，以及结构体切片会被递归校验
+type Validator struct {
+	mutex sync.RWMutex
+	rules map[string]ValidateFunc
+	// 语言 => 规则 => 模板
+	translations map[string]map[string]string
+	defaultLang  string
+}
+
+func NewValidator() *Validator {
+	v := &Validator{
+		rules: map[string]ValidateFunc{
+			"min":      validateMin,
+			"max":      validateMax,
+			"len":      validateLen,
+			"email":    validateEmail,
+			"oneof":    validateOneOf,
+			"eqfield":  crossField(func(res int) bool { return res == 0 }),
+			"nefield":  crossField(func(res int) bool { return res != 0 }),
+			"gtfield":  crossField(func(res int) bool { return res > 0 }),
+			"gtefield": crossField(func(res int) bool { return res >= 0 }),
+			"ltfield":  crossField(func(res int) bool { return res < 0 }),
+			"ltefield": crossField(func(res int) bool { return res <= 0 }),
+		},
+		translations: map[string]map[string]string{},
+		defaultLang:  "zh",
+	}
+	for lang, tpls := range defaultTranslations {
+		for tag, tpl := range tpls {
+			v.RegisterTranslation(lang, tag, tpl)
+		}
+	}
+	return v
+}
+
+// RegisterRule 注册自定义的校验规则，同名的规则会被覆盖
+func (v *Validator) RegisterRule(name string, fn ValidateFunc) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.rules[name] = fn
+}
+
+// RegisterTranslation 注册错误信息的模板
+// 模板里面可以使用 {field}, {param} 两个占位符
+func (v *Validator) RegisterTranslation(lang string, tag string, tpl string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	tpls, ok := v.translations[lang]
+	if !ok {
+		tpls = make(map[string]string, 16)
+		v.translations[lang] = tpls
+	}
+	tpls[tag] = tpl
+}
+
+// Translate 把错误信息转换为 lang 对应的语言
+// lang 可以是 zh-CN 这种形式，找不到的时候会退化为 zh，再找不到就用默认语言
+func (v *Validator) Translate(errs ValidationErrors, lang string) ValidationErrors {
+	res := make(ValidationErrors, 0, len(errs))
+	for _, fe := range errs {
+		cp := *fe
+		cp.Message = v.message(&cp, lang)
+		res = append(res, &cp)
+	}
+	return res
+}
+
+func (v *Validator) message(fe *FieldError, lang string) string {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	tpls, ok := v.translations[lang]
+	if !ok {
+		base, _, _ := strings.Cut(lang, "-")
+		tpls, ok = v.translations[base]
+	}
+	if !ok {
+		tpls = v.translations[v.defaultLang]
+	}
+	tpl, ok := tpls[fe.Tag]
+	if !ok {
+		tpl, ok = tpls[""]
+	}
+	if !ok {
+		tpl = "{field} {tag}"
+	}
+	return strings.NewReplacer("{field}", fe.Field, "{param}", fe.Param, "{tag}", fe.Tag).Replace(tpl)
+}
+
+// Validate 校验 val，val 必须是结构体或者结构体指针
+// 校验失败的时候返回 ValidationErrors
+func (v *Validator) Validate(val any) error {
+	rv := reflect.ValueOf(val)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return fmt.Errorf("web: 不能校验 nil")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("web: 只能校验结构体，但是输入是 %s", rv.Kind())
+	}
+	var errs ValidationErrors
+	v.validateStruct(rv, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (v *Validator) validateStruct(rv reflect.Value, prefix string, errs *ValidationErrors) {
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		fd := typ.Field(i)
+		if !fd.IsExported() {
+			continue
+		}
+		tag := fd.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+		fieldName := prefix + jsonName(fd)
+		if fd.Anonymous {
+			fieldName = strings.TrimSuffix(prefix, ".")
+		}
+		fv := rv.Field(i)
+		if tag != "" && !v.validateField(fv, rv, fieldName, strings.Split(tag, ","), errs) {
+			continue
+		}
+		v.validateNested(fv, fieldName, fd.Anonymous, errs)
+	}
+}
+
+// validateField 返回值代表是否通过了校验
+// 一个字段只会报告第一个失败的规则
+func (v *Validator) validateField(fv reflect.Value, parent reflect.Value, fieldName string,
+	rules []string, errs *ValidationErrors) bool {
+	for i, rule := range rules {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "":
+			continue
+		case "required":
+			if fv.IsZero() {
+				*errs = append(*errs, v.newFieldError(fieldName, name, param, fv))
+				return false
+			}
+			continue
+		case "omitempty":
+			if fv.IsZero() {
+				return true
+			}
+			continue
+		case "dive":
+			elem := indirect(fv)
+			if elem.Kind() != reflect.Slice && elem.Kind() != reflect.Array {
+				panic(fmt.Sprintf("web: 字段 %s 不是切片，不能使用 dive", fieldName))
+			}
+			ok := true
+			for j := 0; j < elem.Len(); j++ {
+				if !v.validateField(elem.Index(j), parent, fmt.Sprintf("%s[%d]", fieldName, j), rules[i+1:], errs) {
+					ok = false
+				}
+			}
+			return ok
+		}
+		v.mutex.RLock()
+		fn, ok := v.rules[name]
+		v.mutex.RUnlock()
+		if !ok {
+			// 这是开发者写错了标签，和路由冲突一样直接 panic
+			panic(fmt.Sprintf("web: 未知的校验规则 %s", name))
+		}
+		val := indirect(fv)
+		if !val.IsValid() {
+			// nil 指针代表没有输入，只有 required 关心
+			continue
+		}
+		if !fn(FieldValue{Value: val, Param: param, Parent: parent}) {
+			*errs = append(*errs, v.newFieldError(fieldName, name, param, fv))
+			return false
+		}
+	}
+	return true
+}
+
+// validateNested 递归校验嵌套的结构体，以及结构体切片
+func (v *Validator) validateNested(fv reflect.Value, fieldName string, anonymous bool, errs *ValidationErrors) {
+	fv = indirect(fv)
+	if !fv.IsValid() {
+		return
+	}
+	switch fv.Kind() {
+	case reflect.Struct:
+		if isScalarStruct(fv.Type()) {
+			return
+		}
+		prefix := fieldName + "."
+		if anonymous && fieldName == "" {
+			prefix = ""
+		}
+		v.validateStruct(fv, prefix, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			elem := indirect(fv.Index(i))
+			if elem.Kind() == reflect.Struct && !isScalarStruct(elem.Type()) {
+				v.validateStruct(elem, fmt.Sprintf("%s[%d].", fieldName, i), errs)
+			}
+		}
+	}
+}
+
+func (v *Validator) newFieldError(field string, tag string, param string, fv reflect.Value) *FieldError {
+	fe := &FieldError{
+		Field: field,
+		Tag:   tag,
+		Param: param,
+	}
+	if fv.CanInterface() {
+		fe.Value = fv.Interface()
+	}
+	fe.Message = v.message(fe, v.defaultLang)
+	return fe
+}
+
+// Validator 返回服务器上的 Validator
+func (c *Context) Validator() *Validator {
+	if c.server == nil || c.server.validator == nil {
+		return defaultValidator
+	}
+	return c.server.validator
+}
+
+// Validate 用服务器上的 Validator 校验 val
+func (c *Context) Validate(val any) error {
+	return c.Validator().Validate(val)
+}
+
+// BindAndValidate 先 Bind 再校验，大多数 handler 只需要调用这一个方法
+func (c *Context) BindAndValidate(val any) error {
+	if err := c.Bind(val); err != nil {
+		return err
+	}
+	return c.Validate(val)
+}
+
+func jsonName(fd reflect.StructField) string {
+	name, _, _ := strings.Cut(fd.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return fd.Name
+	}
+	return name
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// size 数字就是它本身，字符串是字符数，切片和 map 是元素个数
+func size(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+func compareSize(field FieldValue, cmp func(size float64, param float64) bool) bool {
+	param, err := strconv.ParseFloat(field.Param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("web: 校验规则的参数 %s 不是数字", field.Param))
+	}
+	s, ok := size(field.Value)
+	return ok && cmp(s, param)
+}
+
+func validateMin(field FieldValue) bool {
+	return compareSize(field, func(size float64, param float64) bool { return size >= param })
+}
+
+func validateMax(field FieldValue) bool {
+	return compareSize(field, func(size float64, param float64) bool { return size <= param })
+}
+
+func validateLen(field FieldValue) bool {
+	return compareSize(field, func(size float64, param float64) bool { return size == param })
+}
+
+func validateEmail(field FieldValue) bool {
+	if field.Value.Kind() != reflect.String {
+		return false
+	}
+	addr, err := mail.ParseAddress(field.Value.String())
+	// 排除掉 "Tom <tom@example.com>" 这种形式
+	return err == nil && addr.Address == field.Value.String()
+}
+
+func validateOneOf(field FieldValue) bool {
+	val := fmt.Sprint(field.Value.Interface())
+	for _, opt := range strings.Fields(field.Param) {
+		if opt == val {
+			return true
+		}
+	}
+	return false
+}
+
+// crossField 和同一个结构体里面的另外一个字段比较
+func crossField(check func(res int) bool) ValidateFunc {
+	return func(field FieldValue) bool {
+		other := field.Parent.FieldByName(field.Param)
+		if !other.IsValid() {
+			panic(fmt.Sprintf("web: 找不到字段 %s", field.Param))
+		}
+		other = indirect(other)
+		if !other.IsValid() {
+			return false
+		}
+		res, ok := compare(field.Value, other)
+		return ok && check(res)
+	}
+}
+
+// compare 比较两个值，第二个返回值代表能不能比较
+func compare(x, y reflect.Value) (int, bool) {
+	if x.Type() != y.Type() {
+		return 0, false
+	}
+	if x.Type() == timeType {
+		xt, yt := x.Interface().(time.Time), y.Interface().(time.Time)
+		switch {
+		case xt.Before(yt):
+			return -1, true
+		case xt.After(yt):
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x.Kind() {
+	case reflect.String:
+		return strings.Compare(x.String(), y.String()), true
+	case reflect.Bool:
+		if x.Bool() == y.Bool() {
+			return 0, true
+		}
+		return 1, true
+	}
+	xs, ok := size(x)
+	if !ok {
+		return 0, false
+	}
+	ys, _ := size(y)
+	switch {
+	case xs < ys:
+		return -1, true
+	case xs > ys:
+		return 1, true
+	}
+	return 0, true
+}
+
+var defaultTranslations = map[string]map[string]string{
+	"zh": {
+		"":         "{field} 校验失败，规则 {tag}",
+		"required": "{field} 不能为空",
+		"min":      "{field} 不能小于 {param}",
+		"max":      "{field} 不能大于 {param}",
+		"len":      "{field} 的长度必须是 {param}",
+		"email":    "{field} 必须是合法的邮箱",
+		"oneof":    "{field} 必须是 [{param}] 中的一个",
+		"eqfield":  "{field} 必须和 {param} 相等",
+		"nefield":  "{field} 不能和 {param} 相等",
+		"gtfield":  "{field} 必须大于 {param}",
+		"gtefield": "{field} 必须大于等于 {param}",
+		"ltfield":  "{field} 必须小于 {param}",
+		"ltefield": "{field} 必须小于等于 {param}",
+	},
+	"en": {
+		"":         "{field} failed on the {tag} rule",
+		"required": "{field} is required",
+		"min":      "{field} must be at least {param}",
+		"max":      "{field} must be at most {param}",
+		"len":      "{field} must have length {param}",
+		"email":    "{field} must be a valid email address",
+		"oneof":    "{field} must be one of [{param}]",
+		"eqfield":  "{field} must be equal to {param}",
+		"nefield":  "{field} must not be equal to {param}",
+		"gtfield":  "{field} must be greater than {param}",
+		"gtefield": "{field} must be greater than or equal to {param}",
+		"ltfield":  "{field} must be less than {param}",
+		"ltefield": "{field} must be less than or equal to {param}",
+	},
+}
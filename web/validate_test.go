@@ -0,0 +1,138 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateItem struct {
+	Name  string `json:"name" validate:"required"`
+	Count int    `json:"count" validate:"min=1"`
+}
+
+type validateUser struct {
+	Name            string         `json:"name" validate:"required,min=1,max=8"`
+	Email           string         `json:"email" validate:"omitempty,email"`
+	Role            string         `json:"role" validate:"oneof=admin user"`
+	Age             *int           `json:"age" validate:"omitempty,min=18"`
+	Password        string         `json:"password"`
+	ConfirmPassword string         `json:"confirm_password" validate:"eqfield=Password"`
+	Tags            []string       `json:"tags" validate:"max=2,dive,len=2"`
+	Items           []validateItem `json:"items"`
+	Address         *struct {
+		City string `json:"city" validate:"required"`
+	} `json:"address"`
+	Ignore string `validate:"-"`
+}
+
+func TestValidator_Validate(t *testing.T) {
+	age := 16
+	testCases := []struct {
+		name     string
+		val      any
+		wantErrs []string
+	}{
+		{
+			name: "valid",
+			val: &validateUser{
+				Name:            "Tom",
+				Email:           "tom@example.com",
+				Role:            "admin",
+				Password:        "123",
+				ConfirmPassword: "123",
+				Tags:            []string{"ab", "cd"},
+				Items:           []validateItem{{Name: "book", Count: 1}},
+			},
+		},
+		{
+			name: "invalid",
+			val: validateUser{
+				Email:           "Tom <tom@example.com>",
+				Role:            "root",
+				Age:             &age,
+				Password:        "123",
+				ConfirmPassword: "456",
+				Tags:            []string{"ab", "c"},
+				Items:           []validateItem{{Name: "book", Count: 1}, {Count: 0}},
+				Address: &struct {
+					City string `json:"city" validate:"required"`
+				}{},
+			},
+			wantErrs: []string{
+				"name:required", "email:email", "role:oneof", "age:min",
+				"confirm_password:eqfield", "tags[1]:len", "items[1].name:required",
+				"items[1].count:min", "address.city:required",
+			},
+		},
+		{
+			name:     "not struct",
+			val:      123,
+			wantErrs: []string{},
+		},
+	}
+
+	v := NewValidator()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.Validate(tc.val)
+			if tc.wantErrs == nil {
+				assert.NoError(t, err)
+				return
+			}
+			if len(tc.wantErrs) == 0 {
+				assert.Error(t, err)
+				return
+			}
+			errs, ok := err.(ValidationErrors)
+			assert.True(t, ok)
+			res := make([]string, 0, len(errs))
+			for _, fe := range errs {
+				res = append(res, fe.Field+":"+fe.Tag)
+			}
+			assert.Equal(t, tc.wantErrs, res)
+		})
+	}
+}
+
+func TestValidator_Translate(t *testing.T) {
+	v := NewValidator()
+	err := v.Validate(validateItem{Name: "book"})
+	errs := err.(ValidationErrors)
+	assert.Equal(t, "count 不能小于 1", errs[0].Message)
+
+	assert.Equal(t, "count must be at least 1", v.Translate(errs, "en-US")[0].Message)
+	// 找不到的语言用默认的
+	assert.Equal(t, "count 不能小于 1", v.Translate(errs, "fr")[0].Message)
+
+	v.RegisterTranslation("fr", "min", "{field} doit être au moins {param}")
+	assert.Equal(t, "count doit être au moins 1", v.Translate(errs, "fr")[0].Message)
+	// 原本的错误不会被修改
+	assert.Equal(t, "count 不能小于 1", errs[0].Message)
+}
+
+func TestHTTPServer_RegisterValidation(t *testing.T) {
+	server := NewHTTPServer()
+	server.RegisterValidation("even", func(field FieldValue) bool {
+		return field.Value.Kind() == reflect.Int && field.Value.Int()%2 == 0
+	})
+	type req struct {
+		Num int `query:"num" validate:"even"`
+	}
+	var errs []error
+	server.Get("/num", func(ctx *Context) {
+		var r req
+		errs = append(errs, ctx.BindAndValidate(&r))
+	})
+	for _, url := range []string{"/num?num=2", "/num?num=3"} {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.ServeHTTP(nil, request)
+	}
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "Num 校验失败，规则 even", errs[1].(ValidationErrors)[0].Message)
+}
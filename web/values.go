@@ -0,0 +1,115 @@
+package web
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	// ErrKeyNotFound 代表输入里面根本没有这个 key
+	ErrKeyNotFound = errors.New("web: key 不存在")
+	// ErrEmptyValue 代表有这个 key，但是值是空字符串，没办法转换为非字符串的类型
+	// 比如说 ?page= 这种
+	ErrEmptyValue = errors.New("web: 值为空字符串")
+)
+
+// QueryValues 返回 key 对应的所有值，比如说 ?tag=a&tag=b
+func (c *Context) QueryValues(key string) ([]string, error) {
+	if c.queryValues == nil {
+		c.queryValues = c.Req.URL.Query()
+	}
+	vals, ok := c.queryValues[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return vals, nil
+}
+
+// As 把 StringValue 转换为 T
+// 支持所有宽度的 int, uint 和 float，bool, string, time.Duration,
+// time.Time 和实现了 encoding.TextUnmarshaler 的类型
+// layouts 是 time.Time 的格式，会依次尝试，默认是 RFC3339
+// 没有这个 key 的时候返回 ErrKeyNotFound，值为空字符串并且 T 不是字符串的时候返回 ErrEmptyValue
+func As[T any](sv StringValue, layouts ...string) (T, error) {
+	var t T
+	if sv.err != nil {
+		return t, sv.err
+	}
+	if err := convert(&t, sv.val, layouts); err != nil {
+		var zero T
+		return zero, err
+	}
+	return t, nil
+}
+
+// AsOrDefault 没有这个 key，或者转换失败的时候返回 def
+func AsOrDefault[T any](sv StringValue, def T, layouts ...string) T {
+	res, err := As[T](sv, layouts...)
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+// MustAs 转换失败的时候会 panic
+// 只适合那些前面已经校验过的输入
+func MustAs[T any](sv StringValue, layouts ...string) T {
+	res, err := As[T](sv, layouts...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// QueryAs 把查询参数转换为 T，例如 QueryAs[int](ctx, "page")
+func QueryAs[T any](c *Context, key string, layouts ...string) (T, error) {
+	return As[T](c.QueryValueV1(key), layouts...)
+}
+
+// QueryAsOrDefault 没有这个查询参数，或者转换失败的时候返回 def
+func QueryAsOrDefault[T any](c *Context, key string, def T, layouts ...string) T {
+	return AsOrDefault[T](c.QueryValueV1(key), def, layouts...)
+}
+
+// PathAs 把路径参数转换为 T，例如 PathAs[int64](ctx, "id")
+func PathAs[T any](c *Context, key string, layouts ...string) (T, error) {
+	return As[T](c.PathValueV1(key), layouts...)
+}
+
+// PathAsOrDefault 没有这个路径参数，或者转换失败的时候返回 def
+func PathAsOrDefault[T any](c *Context, key string, def T, layouts ...string) T {
+	return AsOrDefault[T](c.PathValueV1(key), def, layouts...)
+}
+
+// QueryValuesAs 把 key 对应的所有值都转换为 T
+func QueryValuesAs[T any](c *Context, key string, layouts ...string) ([]T, error) {
+	vals, err := c.QueryValues(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]T, len(vals))
+	for i, val := range vals {
+		if err = convert(&res[i], val, layouts); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// convert 复用 Bind 里面的转换逻辑
+func convert(dst any, val string, layouts []string) error {
+	v := reflect.ValueOf(dst).Elem()
+	if val == "" && v.Kind() != reflect.String {
+		return ErrEmptyValue
+	}
+	if len(layouts) == 0 {
+		return setValue(v, []string{val}, "")
+	}
+	var err error
+	for _, layout := range layouts {
+		if err = setValue(v, []string{val}, layout); err == nil {
+			return nil
+		}
+	}
+	return err
+}
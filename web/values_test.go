@@ -0,0 +1,160 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAs(t *testing.T) {
+	var level bindLevel = 2
+	testCases := []struct {
+		name    string
+		convert func() (any, error)
+		wantVal any
+		wantErr error
+	}{
+		{
+			name: "int8",
+			convert: func() (any, error) {
+				return As[int8](StringValue{val: "-12"})
+			},
+			wantVal: int8(-12),
+		},
+		{
+			name: "int8 overflow",
+			convert: func() (any, error) {
+				return As[int8](StringValue{val: "1024"})
+			},
+			wantVal: int8(0),
+			wantErr: errors.New("strconv.ParseInt: parsing \"1024\": value out of range"),
+		},
+		{
+			name: "uint16",
+			convert: func() (any, error) {
+				return As[uint16](StringValue{val: "1024"})
+			},
+			wantVal: uint16(1024),
+		},
+		{
+			name: "float32",
+			convert: func() (any, error) {
+				return As[float32](StringValue{val: "1.5"})
+			},
+			wantVal: float32(1.5),
+		},
+		{
+			name: "bool",
+			convert: func() (any, error) {
+				return As[bool](StringValue{val: "true"})
+			},
+			wantVal: true,
+		},
+		{
+			name: "duration",
+			convert: func() (any, error) {
+				return As[time.Duration](StringValue{val: "1m30s"})
+			},
+			wantVal: 90 * time.Second,
+		},
+		{
+			name: "time layouts",
+			convert: func() (any, error) {
+				return As[time.Time](StringValue{val: "2022-10-01"}, time.RFC3339, "2006-01-02")
+			},
+			wantVal: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "text unmarshaler",
+			convert: func() (any, error) {
+				return As[bindLevel](StringValue{val: "high"})
+			},
+			wantVal: level,
+		},
+		{
+			name: "pointer",
+			convert: func() (any, error) {
+				return As[*bindLevel](StringValue{val: "high"})
+			},
+			wantVal: &level,
+		},
+		{
+			name: "empty string",
+			convert: func() (any, error) {
+				return As[string](StringValue{val: ""})
+			},
+			wantVal: "",
+		},
+		{
+			name: "empty int",
+			convert: func() (any, error) {
+				return As[int](StringValue{val: ""})
+			},
+			wantVal: 0,
+			wantErr: ErrEmptyValue,
+		},
+		{
+			name: "missing",
+			convert: func() (any, error) {
+				return As[int](StringValue{err: ErrKeyNotFound})
+			},
+			wantVal: 0,
+			wantErr: ErrKeyNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			val, err := tc.convert()
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestAsOrDefault(t *testing.T) {
+	assert.Equal(t, 10, AsOrDefault[int](StringValue{err: ErrKeyNotFound}, 10))
+	assert.Equal(t, 10, AsOrDefault[int](StringValue{val: "abc"}, 10))
+	assert.Equal(t, 12, AsOrDefault[int](StringValue{val: "12"}, 10))
+	assert.Equal(t, 12, MustAs[int](StringValue{val: "12"}))
+	assert.Panics(t, func() {
+		MustAs[int](StringValue{val: "abc"})
+	})
+}
+
+func TestQueryAs(t *testing.T) {
+	ctx := &Context{
+		Req:        httptest.NewRequest(http.MethodGet, "/user/12?page=2&size=&tag=1&tag=2", nil),
+		PathParams: map[string]string{"id": "12"},
+	}
+	page, err := QueryAs[int](ctx, "page")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, page)
+
+	_, err = QueryAs[int](ctx, "size")
+	assert.Equal(t, ErrEmptyValue, err)
+	_, err = QueryAs[int](ctx, "limit")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 20, QueryAsOrDefault[int](ctx, "size", 20))
+
+	id, err := PathAs[int64](ctx, "id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), id)
+	assert.Equal(t, uint(1), PathAsOrDefault[uint](ctx, "uid", 1))
+
+	vals, err := ctx.QueryValues("tag")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, vals)
+	tags, err := QueryValuesAs[uint8](ctx, "tag")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint8{1, 2}, tags)
+	_, err = QueryValuesAs[uint8](ctx, "limit")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
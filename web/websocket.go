@@ -0,0 +1,696 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID RFC 6455 里面规定的，用来计算 Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	defaultWSMaxMessageSize = 1 << 20
+	defaultWSCloseTimeout   = 5 * time.Second
+	// wsCompressThreshold 太小的消息压缩之后反而可能更大
+	wsCompressThreshold = 128
+)
+
+// WSMessageType 消息类型
+type WSMessageType byte
+
+const (
+	WSTextMessage   WSMessageType = 1
+	WSBinaryMessage WSMessageType = 2
+)
+
+const (
+	wsOpContinuation byte = 0
+	wsOpText         byte = 1
+	wsOpBinary       byte = 2
+	wsOpClose        byte = 8
+	wsOpPing         byte = 9
+	wsOpPong         byte = 10
+)
+
+// 关闭码，参考 RFC 6455 7.4.1
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	// WSCloseNoStatus 对端的关闭帧里面没有关闭码，不能用来发送
+	WSCloseNoStatus = 1005
+	// WSCloseAbnormal 连接异常断开，不能用来发送
+	WSCloseAbnormal        = 1006
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseMessageTooBig   = 1009
+	WSCloseInternalError   = 1011
+)
+
+// ErrWSClosed 已经发送过关闭帧了，不能再发送数据
+var ErrWSClosed = errors.New("web: websocket 连接已经关闭")
+
+// WSCloseError 连接被关闭了
+// 可能是对端发送了关闭帧，也可能是对端违反了协议，被我们关闭了
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("web: websocket 连接关闭, code: %d, reason: %s", e.Code, e.Reason)
+}
+
+// WebSocketHandler handler 返回之后，连接会被正常关闭
+type WebSocketHandler func(ctx *Context, conn *WebSocketConn)
+
+// WebSocketUpgrader 负责握手，字段都是可选的
+type WebSocketUpgrader struct {
+	// MaxMessageSize 一个消息的最大字节数，分片的消息按照拼接之后的大小计算，
+	// 压缩的消息按照解压之后的大小计算。默认是 1M
+	MaxMessageSize int64
+	// FragmentSize 发送的消息超过这个大小就分片发送，0 代表不分片
+	FragmentSize int
+	// PingInterval 每隔多久发送一次 ping，0 代表不发送
+	// 超过两个周期没有收到任何数据，就认为连接已经断开了
+	PingInterval time.Duration
+	// CloseTimeout 发送关闭帧之后，等待对端关闭帧的时间，默认是 5 秒
+	CloseTimeout time.Duration
+	// EnableCompression 客户端支持的话，启用 permessage-deflate
+	EnableCompression bool
+	// Subprotocols 服务端支持的子协议，按照优先级排列
+	Subprotocols []string
+	// CheckOrigin 返回 false 会拒绝握手
+	// 默认要求 Origin 和 Host 一致，没有 Origin 的（比如说非浏览器的客户端）可以通过
+	CheckOrigin func(req *http.Request) bool
+}
+
+// ServerWithWebSocketUpgrader 设置 HTTPServer.WebSocket 使用的 WebSocketUpgrader
+func ServerWithWebSocketUpgrader(u *WebSocketUpgrader) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.wsUpgrader = u
+	}
+}
+
+// WebSocket 注册一个 websocket 路由
+// mdls 是路由级别的 middleware，比如说鉴权，它们会在握手之前执行，
+// 可以通过不调用 next 来拒绝握手
+func (h *HTTPServer) WebSocket(path string, handler WebSocketHandler, mdls ...Middleware) {
+	h.addRoute(http.MethodGet, path, func(ctx *Context) {
+		upgrader := h.wsUpgrader
+		if upgrader == nil {
+			upgrader = &WebSocketUpgrader{}
+		}
+		conn, err := upgrader.Upgrade(ctx)
+		if err != nil {
+			// 响应已经在 Upgrade 里面设置好了
+			return
+		}
+		handler(ctx, conn)
+		_ = conn.Close(WSCloseNormal, "")
+	}, mdls...)
+}
+
+// Upgrade 完成握手，接管连接
+// 握手失败的时候，会设置好对应的响应码，并且返回 error
+func (u *WebSocketUpgrader) Upgrade(ctx *Context) (*WebSocketConn, error) {
+	req := ctx.Req
+	fail := func(status int, msg string) (*WebSocketConn, error) {
+		ctx.RespStatusCode = status
+		ctx.RespData = []byte(msg)
+		return nil, errors.New("web: websocket 握手失败: " + msg)
+	}
+	if req.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "websocket 握手必须是 GET 请求")
+	}
+	if !headerHasToken(req.Header, "Connection", "upgrade") ||
+		!headerHasToken(req.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "不是 websocket 握手请求")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.RespHeader().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "不支持的 websocket 版本")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "Sec-WebSocket-Key 不合法")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return fail(http.StatusForbidden, "Origin 不允许")
+	}
+	hijacker, ok := ctx.Resp.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "不支持 Hijack")
+	}
+
+	subprotocol := u.selectSubprotocol(req)
+	compress := u.EnableCompression && offersDeflate(req.Header)
+	// middleware 设置的响应头，比如说 Set-Cookie，也一起发送
+	extra := ctx.RespHeader().Clone()
+
+	netConn, brw, err := hijacker.Hijack()
+	if err != nil {
+		ctx.HandlerErr = err
+		return fail(http.StatusInternalServerError, "Hijack 失败")
+	}
+	// http.Server 的 ReadTimeout 和 WriteTimeout 设置的超时时间在 Hijack 之后依旧有效，
+	// 不清掉的话，长连接到时间就会被断开
+	if err = netConn.SetDeadline(time.Time{}); err != nil {
+		_ = netConn.Close()
+		ctx.HandlerErr = err
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n")
+	if subprotocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		buf.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	for _, k := range []string{"Upgrade", "Connection", "Content-Length", "Content-Type"} {
+		extra.Del(k)
+	}
+	for k := range extra {
+		if strings.HasPrefix(k, "Sec-Websocket-") {
+			extra.Del(k)
+		}
+	}
+	_ = extra.Write(buf)
+	buf.WriteString("\r\n")
+	if _, err = netConn.Write(buf.Bytes()); err != nil {
+		_ = netConn.Close()
+		ctx.HandlerErr = err
+		return nil, err
+	}
+	ctx.RespStatusCode = http.StatusSwitchingProtocols
+
+	conn := &WebSocketConn{
+		conn:           netConn,
+		br:             brw.Reader,
+		subprotocol:    subprotocol,
+		compress:       compress,
+		maxMessageSize: u.MaxMessageSize,
+		fragmentSize:   u.FragmentSize,
+		pingInterval:   u.PingInterval,
+		closeTimeout:   u.CloseTimeout,
+		closeReceived:  make(chan struct{}),
+		closed:         make(chan struct{}),
+	}
+	if conn.maxMessageSize <= 0 {
+		conn.maxMessageSize = defaultWSMaxMessageSize
+	}
+	if conn.closeTimeout <= 0 {
+		conn.closeTimeout = defaultWSCloseTimeout
+	}
+	if conn.pingInterval > 0 {
+		go conn.keepalive()
+	}
+	return conn, nil
+}
+
+func (u *WebSocketUpgrader) selectSubprotocol(req *http.Request) string {
+	offered := headerTokens(req.Header, "Sec-WebSocket-Protocol")
+	for _, p := range u.Subprotocols {
+		for _, o := range offered {
+			if o == p {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// WebSocketConn 一个 websocket 连接
+// 读和写可以在不同的 goroutine 里面，但是同一时刻只能有一个 goroutine 在读
+type WebSocketConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	compress    bool
+
+	maxMessageSize int64
+	fragmentSize   int
+	pingInterval   time.Duration
+	closeTimeout   time.Duration
+
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+	closeSent  bool
+
+	// 收到了对端的关闭帧
+	closeReceived     chan struct{}
+	closeReceivedOnce sync.Once
+	closed            chan struct{}
+	closeOnce         sync.Once
+}
+
+// Subprotocol 协商出来的子协议
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr 对端的地址
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage 读取一个完整的消息，分片的消息会被拼接起来，压缩的消息会被解压
+// ping 会被自动回复 pong。对端关闭或者违反协议的时候返回 *WSCloseError
+func (c *WebSocketConn) ReadMessage() (WSMessageType, []byte, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	var (
+		typ        WSMessageType
+		buf        []byte
+		compressed bool
+		started    bool
+	)
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case wsOpPing:
+			_ = c.writeFrame(wsOpPong, f.payload, true, false)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return 0, nil, c.handleClose(f.payload)
+		case wsOpText, wsOpBinary:
+			if started {
+				return 0, nil, c.fail(&WSCloseError{Code: WSCloseProtocolError, Reason: "上一个消息还没有结束"})
+			}
+			started = true
+			typ = WSMessageType(f.opcode)
+			compressed = f.rsv1
+		case wsOpContinuation:
+			if !started {
+				return 0, nil, c.fail(&WSCloseError{Code: WSCloseProtocolError, Reason: "没有需要继续的消息"})
+			}
+		}
+		if int64(len(buf)+len(f.payload)) > c.maxMessageSize {
+			return 0, nil, c.fail(&WSCloseError{Code: WSCloseMessageTooBig, Reason: "消息太大"})
+		}
+		buf = append(buf, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if buf, err = c.decompress(buf); err != nil {
+			return 0, nil, c.fail(err)
+		}
+	}
+	if typ == WSTextMessage && !utf8.Valid(buf) {
+		return 0, nil, c.fail(&WSCloseError{Code: WSCloseInvalidPayload, Reason: "文本消息不是合法的 UTF-8"})
+	}
+	return typ, buf, nil
+}
+
+// WriteMessage 发送一个消息
+func (c *WebSocketConn) WriteMessage(typ WSMessageType, data []byte) error {
+	if typ != WSTextMessage && typ != WSBinaryMessage {
+		return fmt.Errorf("web: 不支持的消息类型 %d", typ)
+	}
+	compressed := false
+	if c.compress && len(data) >= wsCompressThreshold {
+		var err error
+		if data, err = compress(data); err != nil {
+			return err
+		}
+		compressed = true
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	opcode := byte(typ)
+	for {
+		payload, fin := data, true
+		if c.fragmentSize > 0 && len(data) > c.fragmentSize {
+			payload, fin = data[:c.fragmentSize], false
+		}
+		if err := c.writeFrameLocked(opcode, payload, fin, compressed); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data = data[len(payload):]
+		// 只有第一帧需要设置操作码和 RSV1
+		opcode, compressed = wsOpContinuation, false
+	}
+}
+
+// WriteText 发送文本消息
+func (c *WebSocketConn) WriteText(text string) error {
+	return c.WriteMessage(WSTextMessage, []byte(text))
+}
+
+// Ping 发送 ping，对端的 pong 会在 ReadMessage 里面被处理
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.writeFrame(wsOpPing, data, true, false)
+}
+
+// Close 发送关闭帧，等对端回复关闭帧或者超时之后，关闭连接
+// 重复调用是安全的
+func (c *WebSocketConn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err == nil {
+		c.waitClose()
+	}
+	return c.closeConn()
+}
+
+// Done 连接关闭之后返回
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// waitClose 等待对端的关闭帧
+// 如果有别的 goroutine 在 ReadMessage，那么它会收到关闭帧
+func (c *WebSocketConn) waitClose() {
+	if c.readMutex.TryLock() {
+		defer c.readMutex.Unlock()
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.closeTimeout))
+		for {
+			f, err := c.readFrame()
+			if err != nil || f.opcode == wsOpClose {
+				return
+			}
+		}
+	}
+	select {
+	case <-c.closeReceived:
+	case <-c.closed:
+	case <-time.After(c.closeTimeout):
+	}
+}
+
+// handleClose 对端发送了关闭帧，回复之后关闭连接
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	c.closeReceivedOnce.Do(func() {
+		close(c.closeReceived)
+	})
+	closeErr := &WSCloseError{Code: WSCloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		closeErr = &WSCloseError{Code: WSCloseProtocolError, Reason: "关闭帧不合法"}
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			closeErr = &WSCloseError{Code: WSCloseProtocolError, Reason: "关闭码不合法"}
+		} else if !utf8.Valid(payload[2:]) {
+			closeErr = &WSCloseError{Code: WSCloseInvalidPayload, Reason: "关闭原因不是合法的 UTF-8"}
+		}
+	}
+	// 回复同样的关闭码
+	_ = c.writeClose(closeErr.Code, "")
+	_ = c.closeConn()
+	return closeErr
+}
+
+// fail 读取失败，如果是对端违反了协议，那么发送关闭帧
+func (c *WebSocketConn) fail(err error) error {
+	var closeErr *WSCloseError
+	if errors.As(err, &closeErr) {
+		_ = c.writeClose(closeErr.Code, closeErr.Reason)
+	}
+	_ = c.closeConn()
+	return err
+}
+
+func (c *WebSocketConn) closeConn() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *WebSocketConn) keepalive() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Ping(nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *WebSocketConn) readFrame() (*wsFrame, error) {
+	if c.pingInterval > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+	}
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0f,
+	}
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	protocolErr := func(reason string) (*wsFrame, error) {
+		return nil, &WSCloseError{Code: WSCloseProtocolError, Reason: reason}
+	}
+	if header[0]&0x30 != 0 {
+		return protocolErr("RSV2 和 RSV3 必须是 0")
+	}
+	switch f.opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		if f.rsv1 && (!c.compress || f.opcode == wsOpContinuation) {
+			return protocolErr("RSV1 不合法")
+		}
+	case wsOpClose, wsOpPing, wsOpPong:
+		if !f.fin || length > 125 || f.rsv1 {
+			return protocolErr("控制帧不合法")
+		}
+	default:
+		return protocolErr("未知的操作码")
+	}
+	// 客户端发送的帧必须掩码
+	if !masked {
+		return protocolErr("客户端的帧必须掩码")
+	}
+
+	switch length {
+	case 126:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(c.br, buf); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(buf))
+	case 127:
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(c.br, buf); err != nil {
+			return nil, err
+		}
+		if buf[0]&0x80 != 0 {
+			return protocolErr("长度不合法")
+		}
+		length = int64(binary.BigEndian.Uint64(buf))
+	}
+	if length > c.maxMessageSize {
+		return nil, &WSCloseError{Code: WSCloseMessageTooBig, Reason: "消息太大"}
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.br, mask); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte, fin bool, rsv1 bool) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeFrameLocked(opcode, payload, fin, rsv1)
+}
+
+// writeFrameLocked 服务端发送的帧是不掩码的
+func (c *WebSocketConn) writeFrameLocked(opcode byte, payload []byte, fin bool, rsv1 bool) error {
+	if c.closeSent {
+		return ErrWSClosed
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	frame = append(frame, b0)
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeClose 发送关闭帧，只会发送一次
+func (c *WebSocketConn) writeClose(code int, reason string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	var payload []byte
+	if code != WSCloseNoStatus && code != WSCloseAbnormal {
+		// 控制帧最多 125 个字节
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = append([]byte{byte(code >> 8), byte(code)}, reason...)
+	}
+	if err := c.writeFrameLocked(wsOpClose, payload, true, false); err != nil {
+		return err
+	}
+	c.closeSent = true
+	return nil
+}
+
+func (c *WebSocketConn) decompress(data []byte) ([]byte, error) {
+	// 压缩的时候去掉了末尾的 00 00 ff ff，这里要加回去，
+	// 再加上一个空的最终块，这样 flate 才能正常结束
+	tail := []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(tail)))
+	defer r.Close()
+	res, err := io.ReadAll(io.LimitReader(r, c.maxMessageSize+1))
+	if err != nil {
+		return nil, &WSCloseError{Code: WSCloseInvalidPayload, Reason: "解压失败"}
+	}
+	if int64(len(res)) > c.maxMessageSize {
+		return nil, &WSCloseError{Code: WSCloseMessageTooBig, Reason: "消息太大"}
+	}
+	return res, nil
+}
+
+// compress permessage-deflate，不复用上下文
+func compress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	res := buf.Bytes()
+	// 去掉 Flush 产生的 00 00 ff ff
+	return res[:len(res)-4], nil
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// sameOrigin 默认的 Origin 检查
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+func offersDeflate(header http.Header) bool {
+	for _, ext := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		name, _, _ := strings.Cut(ext, ";")
+		if strings.TrimSpace(name) == "permessage-deflate" {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTokens 把逗号分隔的头部拆开
+func headerTokens(header http.Header, key string) []string {
+	var res []string
+	for _, val := range header.Values(key) {
+		for _, token := range strings.Split(val, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				res = append(res, token)
+			}
+		}
+	}
+	return res
+}
+
+func headerHasToken(header http.Header, key string, token string) bool {
+	for _, t := range headerTokens(header, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
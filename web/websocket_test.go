@@ -0,0 +1,425 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wsTestClient 测试用的客户端，可以发送各种不合法的帧
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dialWS(t *testing.T, server *httptest.Server, path string, header map[string]string) *wsTestClient {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if err = req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &wsTestClient{t: t, conn: conn, br: br, resp: resp}
+}
+
+func (c *wsTestClient) writeFrame(fin bool, rsv1 bool, opcode byte, payload []byte, masked bool) {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	frame := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	data := append([]byte{}, payload...)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(frame, data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsTestClient) readFrame() *wsFrame {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		c.t.Fatal(err)
+	}
+	// 服务端的帧不会掩码
+	assert.Zero(c.t, header[1]&0x80)
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		buf := make([]byte, 2)
+		_, _ = io.ReadFull(c.br, buf)
+		length = int(binary.BigEndian.Uint16(buf))
+	case 127:
+		buf := make([]byte, 8)
+		_, _ = io.ReadFull(c.br, buf)
+		length = int(binary.BigEndian.Uint64(buf))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return &wsFrame{
+		fin:     header[0]&0x80 != 0,
+		rsv1:    header[0]&0x40 != 0,
+		opcode:  header[0] & 0x0f,
+		payload: payload,
+	}
+}
+
+func (c *wsTestClient) readClose() int {
+	f := c.readFrame()
+	assert.Equal(c.t, wsOpClose, f.opcode)
+	if len(f.payload) < 2 {
+		return WSCloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func closePayload(code int, reason string) []byte {
+	return append([]byte{byte(code >> 8), byte(code)}, reason...)
+}
+
+func newWSTestServer(t *testing.T, u *WebSocketUpgrader, errs chan error) *httptest.Server {
+	server := NewHTTPServer(ServerWithWebSocketUpgrader(u))
+	auth := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			if ctx.Req.URL.Query().Get("token") != "123" {
+				ctx.RespStatusCode = http.StatusUnauthorized
+				return
+			}
+			ctx.RespHeader().Set("X-User", "Tom")
+			next(ctx)
+		}
+	}
+	server.WebSocket("/echo", func(ctx *Context, conn *WebSocketConn) {
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err = conn.WriteMessage(typ, data); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}, auth)
+	s := httptest.NewServer(server)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHTTPServer_WebSocketHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	s := newWSTestServer(t, &WebSocketUpgrader{Subprotocols: []string{"v2", "v1"}}, errs)
+
+	testCases := []struct {
+		name   string
+		path   string
+		header map[string]string
+
+		wantCode   int
+		wantHeader map[string]string
+	}{
+		{
+			name:     "success",
+			path:     "/echo?token=123",
+			header:   map[string]string{"Sec-WebSocket-Protocol": "v1, v2", "Origin": "http://" + s.Listener.Addr().String()},
+			wantCode: http.StatusSwitchingProtocols,
+			wantHeader: map[string]string{
+				"Sec-WebSocket-Accept":   "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+				"Sec-WebSocket-Protocol": "v2",
+				// 路由上的 middleware 在握手之前执行
+				"X-User": "Tom",
+			},
+		},
+		{
+			name:     "unauthorized",
+			path:     "/echo",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "cross origin",
+			path:     "/echo?token=123",
+			header:   map[string]string{"Origin": "http://evil.com"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:       "version",
+			path:       "/echo?token=123",
+			header:     map[string]string{"Sec-WebSocket-Version": "8"},
+			wantCode:   http.StatusUpgradeRequired,
+			wantHeader: map[string]string{"Sec-WebSocket-Version": "13"},
+		},
+		{
+			name:     "bad key",
+			path:     "/echo?token=123",
+			header:   map[string]string{"Sec-WebSocket-Key": "abc"},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialWS(t, s, tc.path, tc.header)
+			assert.Equal(t, tc.wantCode, c.resp.StatusCode)
+			for k, v := range tc.wantHeader {
+				assert.Equal(t, v, c.resp.Header.Get(k), k)
+			}
+		})
+	}
+}
+
+func TestWebSocketConn_ReadMessage(t *testing.T) {
+	errs := make(chan error, 1)
+	s := newWSTestServer(t, &WebSocketUpgrader{MaxMessageSize: 16}, errs)
+
+	testCases := []struct {
+		name   string
+		frames func(c *wsTestClient)
+
+		wantFrames []*wsFrame
+		wantClose  int
+		wantErr    error
+	}{
+		{
+			name: "text",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpText, []byte("hello"), true)
+			},
+			wantFrames: []*wsFrame{{fin: true, opcode: wsOpText, payload: []byte("hello")}},
+		},
+		{
+			name: "binary",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpBinary, []byte{0xff, 0x00}, true)
+			},
+			wantFrames: []*wsFrame{{fin: true, opcode: wsOpBinary, payload: []byte{0xff, 0x00}}},
+		},
+		{
+			// 分片中间夹着 ping
+			name: "fragmented",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(false, false, wsOpText, []byte("hel"), true)
+				c.writeFrame(true, false, wsOpPing, []byte("p"), true)
+				c.writeFrame(true, false, wsOpContinuation, []byte("lo"), true)
+			},
+			wantFrames: []*wsFrame{
+				{fin: true, opcode: wsOpPong, payload: []byte("p")},
+				{fin: true, opcode: wsOpText, payload: []byte("hello")},
+			},
+		},
+		{
+			name: "close",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpClose, closePayload(WSCloseGoingAway, "bye"), true)
+			},
+			wantClose: WSCloseGoingAway,
+			wantErr:   &WSCloseError{Code: WSCloseGoingAway, Reason: "bye"},
+		},
+		{
+			name: "too big",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(false, false, wsOpText, []byte("0123456789"), true)
+				c.writeFrame(true, false, wsOpContinuation, []byte("0123456789"), true)
+			},
+			wantClose: WSCloseMessageTooBig,
+			wantErr:   &WSCloseError{Code: WSCloseMessageTooBig, Reason: "消息太大"},
+		},
+		{
+			name: "unmasked",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpText, []byte("hello"), false)
+			},
+			wantClose: WSCloseProtocolError,
+			wantErr:   &WSCloseError{Code: WSCloseProtocolError, Reason: "客户端的帧必须掩码"},
+		},
+		{
+			name: "invalid utf8",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpText, []byte{0xff, 0xfe}, true)
+			},
+			wantClose: WSCloseInvalidPayload,
+			wantErr:   &WSCloseError{Code: WSCloseInvalidPayload, Reason: "文本消息不是合法的 UTF-8"},
+		},
+		{
+			// 没有协商压缩，不能设置 RSV1
+			name: "rsv1",
+			frames: func(c *wsTestClient) {
+				c.writeFrame(true, true, wsOpText, []byte("hello"), true)
+			},
+			wantClose: WSCloseProtocolError,
+			wantErr:   &WSCloseError{Code: WSCloseProtocolError, Reason: "RSV1 不合法"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialWS(t, s, "/echo?token=123", nil)
+			assert.Equal(t, http.StatusSwitchingProtocols, c.resp.StatusCode)
+			tc.frames(c)
+			for _, want := range tc.wantFrames {
+				assert.Equal(t, want, c.readFrame())
+			}
+			if tc.wantClose == 0 {
+				// 客户端主动关闭
+				c.writeFrame(true, false, wsOpClose, closePayload(WSCloseNormal, ""), true)
+				tc.wantClose = WSCloseNormal
+				tc.wantErr = &WSCloseError{Code: WSCloseNormal}
+			}
+			assert.Equal(t, tc.wantClose, c.readClose())
+			assert.Equal(t, tc.wantErr, <-errs)
+		})
+	}
+}
+
+func TestWebSocketConn_compression(t *testing.T) {
+	errs := make(chan error, 1)
+	s := newWSTestServer(t, &WebSocketUpgrader{EnableCompression: true, FragmentSize: 64}, errs)
+	c := dialWS(t, s, "/echo?token=123", map[string]string{
+		"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits",
+	})
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		c.resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	msg := strings.Repeat("hello websocket ", 64)
+	data, err := compress([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.writeFrame(true, true, wsOpText, data, true)
+
+	// 压缩之后分片发送回来
+	var payload []byte
+	f := c.readFrame()
+	assert.True(t, f.rsv1)
+	assert.Equal(t, wsOpText, f.opcode)
+	for {
+		payload = append(payload, f.payload...)
+		if f.fin {
+			break
+		}
+		f = c.readFrame()
+		assert.Equal(t, wsOpContinuation, f.opcode)
+		assert.False(t, f.rsv1)
+	}
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(payload),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff})))
+	res, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg, string(res))
+
+	// 短消息不压缩
+	c.writeFrame(true, false, wsOpText, []byte("hi"), true)
+	assert.Equal(t, &wsFrame{fin: true, opcode: wsOpText, payload: []byte("hi")}, c.readFrame())
+}
+
+func TestWebSocketConn_Close(t *testing.T) {
+	closed := make(chan error, 1)
+	server := NewHTTPServer(ServerWithWebSocketUpgrader(&WebSocketUpgrader{
+		PingInterval: 10 * time.Millisecond,
+		CloseTimeout: time.Second,
+	}))
+	server.WebSocket("/ws", func(ctx *Context, conn *WebSocketConn) {
+		_ = conn.WriteText("welcome")
+		<-time.After(30 * time.Millisecond)
+		closed <- conn.Close(WSClosePolicyViolation, "go away")
+		// 关闭之后不能再发送
+		closed <- conn.WriteText("hello")
+	})
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	c := dialWS(t, s, "/ws", nil)
+	assert.Equal(t, &wsFrame{fin: true, opcode: wsOpText, payload: []byte("welcome")}, c.readFrame())
+	// 服务端定时发送 ping
+	f := c.readFrame()
+	for f.opcode == wsOpPing {
+		c.writeFrame(true, false, wsOpPong, f.payload, true)
+		f = c.readFrame()
+	}
+	assert.Equal(t, wsOpClose, f.opcode)
+	assert.Equal(t, closePayload(WSClosePolicyViolation, "go away"), f.payload)
+	c.writeFrame(true, false, wsOpClose, closePayload(WSClosePolicyViolation, ""), true)
+	assert.NoError(t, <-closed)
+	assert.True(t, errors.Is(<-closed, ErrWSClosed))
+}
+
+func TestWebSocketConn_serverTimeout(t *testing.T) {
+	server := NewHTTPServer()
+	server.WebSocket("/echo", func(ctx *Context, conn *WebSocketConn) {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(typ, data)
+	})
+	s := httptest.NewUnstartedServer(server)
+	// http.Server 的超时时间不能影响到 websocket 的长连接
+	s.Config.ReadTimeout = 20 * time.Millisecond
+	s.Config.WriteTimeout = 20 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	c := dialWS(t, s, "/echo", nil)
+	assert.Equal(t, http.StatusSwitchingProtocols, c.resp.StatusCode)
+	time.Sleep(60 * time.Millisecond)
+	c.writeFrame(true, false, wsOpText, []byte("hello"), true)
+	assert.Equal(t, &wsFrame{fin: true, opcode: wsOpText, payload: []byte("hello")}, c.readFrame())
+}